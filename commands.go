@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/S4eed3sm/git-proto-gen/protogen"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// newRootCommand builds the CLI. Running the root command without a subcommand generates code,
+// as the tool did before it had subcommands.
+func newRootCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "git-proto-gen",
+		Short: "Generate code from .proto files",
+		Long:  "A CLI tool for generating code from .proto definitions from local or remote GitHub sources.",
+		Args:  cobra.NoArgs,
+		// Errors are reported by main, which knows the requested output format and exit code.
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			cfg.applyTimeout(cmd)
+		},
+		RunE: generateRunE(cfg),
+	}
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return protogen.WithKind(protogen.ErrorKindConfig, err)
+	})
+
+	addSourceFlags(cmd.PersistentFlags(), cfg)
+	addGenerateFlags(cmd.Flags(), cfg)
+
+	cmd.AddCommand(
+		newGenerateCommand(cfg),
+		newFetchCommand(cfg),
+		newPublishCommand(cfg),
+		newLintCommand(cfg),
+		newBreakingCommand(cfg),
+		newCleanCommand(cfg),
+		newInitCommand(cfg),
+		newDoctorCommand(cfg),
+		newVersionCommand(cfg),
+	)
+
+	return cmd
+}
+
+// commandError marks errors returned by a command without a kind as internal, so that main
+// can tell them apart from the errors cobra returns for invalid command lines.
+func commandError(err error) error {
+	return protogen.WithKind(protogen.ErrorKindInternal, err)
+}
+
+// printResult writes the result of a command to w as a single JSON object, e.g.
+// {"result":{"dir":"proto_workspace","sources":[...]}}, when the output format is json. In text
+// format the logs already tell what the command did, so nothing is printed.
+func printResult(w io.Writer, outputFormat string, result any) error {
+	if outputFormat != outputFormatJSON {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(map[string]any{"result": result})
+}
+
+// prepareGenerator loads the manifest and returns a generator for the sources of a command
+// reading protos.
+func prepareGenerator(cmd *cobra.Command, cfg *Config) (*protogen.Generator, error) {
+	manifest, err := cfg.loadManifest(cmd)
+	if err != nil {
+		return nil, protogen.WithKind(protogen.ErrorKindConfig, err)
+	}
+	g, err := cfg.newGenerator(manifest)
+	if err != nil {
+		return nil, protogen.WithKind(protogen.ErrorKindConfig, err)
+	}
+	return g, nil
+}
+
+func generateRunE(cfg *Config) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		g, err := prepareGenerator(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		result, err := g.Generate(cmd.Context())
+		if err != nil {
+			return commandError(err)
+		}
+		return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+	}
+}
+
+func newGenerateCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Fetch the proto sources and generate code for them",
+		Args:  cobra.NoArgs,
+		RunE:  generateRunE(cfg),
+	}
+	addGenerateFlags(cmd.Flags(), cfg)
+	return cmd
+}
+
+func newFetchCommand(cfg *Config) *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:   "fetch",
+		Short: "Fetch the proto sources into a directory without generating code",
+		Long:  "Fetch the proto sources and write them to a directory, laid out and with imports rewritten exactly as they are for code generation.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g, err := prepareGenerator(cmd, cfg)
+			if err != nil {
+				return err
+			}
+			result, err := g.Fetch(cmd.Context(), dir)
+			if err != nil {
+				return commandError(err)
+			}
+			return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "proto_workspace", "Directory to write the fetched .proto files to")
+	return cmd
+}
+
+func newPublishCommand(cfg *Config) *cobra.Command {
+	var targets []string
+	var withGenerated bool
+	cmd := &cobra.Command{
+		Use:   "publish",
+		Short: "Push the fetched proto sources as an OCI artifact",
+		Long: "Fetch and merge the proto sources as the fetch command does and push them to an OCI registry as an artifact, " +
+			"which other projects can use as an oci source. With --with-generated, the files written by the last generate " +
+			"run are pushed too, as a separate layer.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g, err := prepareGenerator(cmd, cfg)
+			if err != nil {
+				return err
+			}
+			result, err := g.Publish(cmd.Context(), protogen.PublishOptions{Targets: targets, WithGenerated: withGenerated})
+			if err != nil {
+				return commandError(err)
+			}
+			return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+		},
+	}
+	cmd.Flags().StringSliceVar(&targets, "to", nil, "OCI reference(s) with a tag to push the artifact to (repeatable, comma-separated), e.g: 'registry.acme.com/schemas/events:1.4.0'")
+	cmd.Flags().BoolVar(&withGenerated, "with-generated", false, "Also push the files written by the last generate run, as recorded in its generation manifest")
+	return cmd
+}
+
+func newLintCommand(cfg *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint",
+		Short: "Lint the proto sources with buf lint",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g, err := prepareGenerator(cmd, cfg)
+			if err != nil {
+				return err
+			}
+			result, err := g.Lint(cmd.Context())
+			if err != nil {
+				return commandError(err)
+			}
+			return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+		},
+	}
+}
+
+func newBreakingCommand(cfg *Config) *cobra.Command {
+	var against string
+	cmd := &cobra.Command{
+		Use:   "breaking",
+		Short: "Check the proto sources for breaking changes with buf breaking",
+		Long: "Check the proto sources for breaking changes against a previous version. --against is either a directory, " +
+			"e.g. one written by the fetch command, or any buf input such as 'https://github.com/acme/protos.git#branch=main,subdir=proto'.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g, err := prepareGenerator(cmd, cfg)
+			if err != nil {
+				return err
+			}
+			result, err := g.Breaking(cmd.Context(), against)
+			if err != nil {
+				return commandError(err)
+			}
+			return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+		},
+	}
+	cmd.Flags().StringVar(&against, "against", "", "Directory or buf input to compare the proto sources against")
+	return cmd
+}
+
+func newCleanCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove the generated files recorded by the last generate run",
+		Long:  "Remove the generated files recorded in the generation manifest. Files the tool did not generate, and generated files modified since, are kept.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := cfg.loadManifest(cmd); err != nil {
+				return protogen.WithKind(protogen.ErrorKindConfig, err)
+			}
+			result, err := protogen.New(cfg.Options).Clean(cmd.Context())
+			if err != nil {
+				return commandError(err)
+			}
+			return commandError(printResult(cmd.OutOrStdout(), cfg.OutputFormat, result))
+		},
+	}
+	addGenerateFlags(cmd.Flags(), cfg)
+	return cmd
+}
+
+func newInitCommand(cfg *Config) *cobra.Command {
+	var force, yes, noBufConfigs bool
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create a project manifest and editable buf configs",
+		Long: "Create a git-proto-gen.yaml project manifest and editable copies of the embedded buf configs. Sources are " +
+			"taken from --local, --public-repo and --private-repo, or detected from the local directories containing .proto " +
+			"files. On a terminal every setting is asked for, with these values as defaults, unless --yes is given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			project := &initProject{
+				manifest: protogen.Manifest{
+					Sources:    sourcesFromFlags(cfg),
+					Languages:  cfg.Languages,
+					Output:     cfg.OutputPath,
+					GoModule:   cfg.GoModule,
+					BufConfigs: cfg.BufConfigsPath,
+				},
+				writeBufConfig: !noBufConfigs,
+			}
+			if len(cfg.LanguageOutputs) > 0 {
+				project.manifest.Outputs = cfg.LanguageOutputs
+			}
+			if cfg.NpmPackage.Name != "" {
+				pkg := cfg.NpmPackage
+				if !flags.Changed("npm-version") {
+					pkg.Version = ""
+				}
+				project.manifest.NpmPackage = &pkg
+			}
+			if project.manifest.BufConfigs == "" && project.writeBufConfig {
+				project.manifest.BufConfigs = defaultBufConfigsDir
+			}
+
+			if len(project.manifest.Sources) == 0 {
+				skip := append([]string{defaultBufConfigsDir}, cfg.OutputDirs()...)
+				dirs, err := protogen.DetectProtoDirs(".", skip...)
+				if err != nil {
+					return commandError(err)
+				}
+				for _, dir := range dirs {
+					project.manifest.Sources = append(project.manifest.Sources, protogen.Source{Type: protogen.SourceTypeLocal, Path: "./" + filepath.ToSlash(dir)})
+				}
+				logger.Info("detected local proto directories", "dirs", dirs)
+			}
+
+			if !yes && term.IsTerminal(int(os.Stdin.Fd())) {
+				p := &prompter{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.ErrOrStderr()}
+				if err := promptInitProject(p, project); err != nil {
+					return protogen.WithKind(protogen.ErrorKindConfig, err)
+				}
+			}
+
+			if err := validateInitProject(project); err != nil {
+				return protogen.WithKind(protogen.ErrorKindConfig, err)
+			}
+			if err := writeInitProject(cfg.ManifestPath, project, force); err != nil {
+				return protogen.WithKind(protogen.ErrorKindOutput, err)
+			}
+
+			logger.Info("created project manifest", "path", cfg.ManifestPath, "sources", len(project.manifest.Sources))
+			return nil
+		},
+	}
+	addGenerateFlags(cmd.Flags(), cfg)
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite an existing manifest and buf configs")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask, use the detected sources and the values of the flags")
+	cmd.Flags().BoolVar(&noBufConfigs, "no-buf-configs", false, "Do not write copies of the embedded buf configs")
+	return cmd
+}
+
+func newDoctorCommand(cfg *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the environment is ready to generate code",
+		Long: "Check Docker and the generator image, git, the SSH agent and the host key of --ssh-host, the GitHub token and " +
+			"its scopes, and that the output directories are writable. Every problem is reported with a hint on how to fix it.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := cfg.loadManifest(cmd); err != nil {
+				return protogen.WithKind(protogen.ErrorKindConfig, err)
+			}
+			checks, err := protogen.New(cfg.Options).Doctor(cmd.Context())
+			if err != nil {
+				return commandError(err)
+			}
+
+			failed, err := printDoctorChecks(cmd.OutOrStdout(), cfg.OutputFormat, checks)
+			if err != nil {
+				return commandError(err)
+			}
+			if failed > 0 {
+				return protogen.WithKind(protogen.ErrorKindCheck, fmt.Errorf("%d of the doctor checks failed", failed))
+			}
+			return nil
+		},
+	}
+	addGenerateFlags(cmd.Flags(), cfg)
+	return cmd
+}
+
+func newVersionCommand(cfg *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print version and build information",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := getBuildInfo()
+			if cfg.OutputFormat == outputFormatJSON {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(info)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "git-proto-gen %s\n  commit: %s\n  built:  %s\n  go:     %s %s\n",
+				info.Version, info.Commit, info.Date, info.GoVersion, info.Platform)
+			return nil
+		},
+	}
+}
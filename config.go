@@ -1,97 +1,218 @@
 package main
 
 import (
-	"embed"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/S4eed3sm/git-proto-gen/protogen"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 const (
-	bufYamlFileName      = "buf.yaml"
-	bufGenGoYamlFileName = "buf.gen.go.yaml"
-	bufGenJsYamlFileName = "buf.gen.js.yaml"
+	outputFormatText = "text"
+	outputFormatJSON = "json"
 )
 
-//go:embed buf/buf.yaml
-var f embed.FS
-var bufYamlContent, _ = f.ReadFile("buf/buf.yaml")
+// Config holds the options of the generator and the flags only the CLI has.
+type Config struct {
+	protogen.Options
+	LocalPaths   []string
+	PrivateRepos []string
+	PublicRepos  []string
+	ManifestPath string
+	OutputFormat string
+	// Timeout cancels the command once it ran for this long, 0 for no limit.
+	Timeout time.Duration
+
+	containerLog  *os.File
+	cancelTimeout context.CancelFunc
+}
 
-//go:embed buf/buf.gen.go.yaml
-var f1 embed.FS
-var bufGenGoYamlContent, _ = f1.ReadFile("buf/buf.gen.go.yaml")
+// addSourceFlags registers the flags shared by every command that reads proto sources.
+func addSourceFlags(flags *pflag.FlagSet, cfg *Config) {
+	flags.StringSliceVar(&cfg.LocalPaths, "local", nil, "Path(s) to local .proto files, a workspace prefix after the last = is optional (repeatable, comma-separated), e.g: './api/proto' or './internal/events/proto=events'")
+	flags.StringArrayVar(&cfg.PrivateRepos, "private-repo", nil, `GitHub path(s) to private proto repos, a ref (branch, tag, commit SHA or semver range) after @ is optional (repeatable), e.g: "github.com/S4eed3sm/private-test-proto/proto@main"`)
+	flags.StringArrayVar(&cfg.PublicRepos, "public-repo", nil, `GitHub path(s) to public proto repos, a ref (branch, tag, commit SHA or semver range) after @ is optional (repeatable), e.g: "github.com/S4eed3sm/public-test-proto/proto@dev"`)
+	flags.StringVar(&cfg.GithubToken, "token", "", "GitHub token for private repos; found in GITHUB_TOKEN, GH_TOKEN, the manifest, ~/.netrc or git credential helpers when not given")
+	flags.Int64Var(&cfg.GithubApp.AppID, "github-app-id", 0, "ID of the GitHub App to authenticate as for private repos, instead of a token or SSH")
+	flags.Int64Var(&cfg.GithubApp.InstallationID, "github-app-installation-id", 0, "Installation ID of the GitHub App; looked up for each repository owner when not set")
+	flags.StringVar(&cfg.GithubApp.PrivateKeyPath, "github-app-key", "", "Path to the private key (PEM) of the GitHub App, e.g: './acme-protos.private-key.pem'")
+	flags.StringVar(&cfg.GithubAPIURL, "github-api-url", protogen.DefaultGithubAPIURL, "Base URL of the GitHub API, e.g: 'https://github.acme.com/api/v3/'")
+	flags.StringVar(&cfg.SSH.KeyPath, "ssh-key", "", "Private SSH key for cloning private repos without a token, instead of the ssh-agent and ~/.ssh/config keys, e.g: '~/.ssh/deploy_key'")
+	flags.StringVar(&cfg.SSH.KnownHostsPath, "ssh-known-hosts", "", "known_hosts file to verify the host key against instead of ssh's defaults; unknown or changed host keys are always rejected")
+	flags.StringVar(&cfg.SSH.Host, "ssh-host", protogen.DefaultSSHHost, "Host to clone private repos from over SSH, may be a Host alias of ~/.ssh/config, e.g: 'github-work'")
+	flags.BoolVar(&cfg.OCIPlainHTTP, "oci-plain-http", false, "Use plain HTTP instead of HTTPS for OCI registries, e.g. for a local registry on localhost:5000")
+	flags.StringVar(&cfg.Container.Image, "image", "", "Generator image by tag or digest, defaults to bufbuild/buf at --buf-version, e.g: 'registry.acme.com/tools/buf@sha256:...'")
+	flags.StringVar(&cfg.Container.BufVersion, "buf-version", "", "buf version of the generator image, "+protogen.DefaultBufVersion+" when neither it nor --image is set; read from the container for other images without it, e.g: '1.50.0'")
+	flags.StringVar(&cfg.Container.Memory, "container-memory", protogen.DefaultContainerMemory, "Memory limit of the generator container, swap included, 0 for none, e.g: '4g'")
+	flags.Float64Var(&cfg.Container.CPUs, "container-cpus", 0, "CPU limit of the generator container, 0 for none, e.g: '1.5'")
+	flags.DurationVar(&cfg.Container.StartupTimeout, "container-startup-timeout", protogen.DefaultContainerStartupTimeout, "How long the generator container may take to start, e.g: '5m'")
+	flags.DurationVar(&cfg.Container.PollInterval, "container-poll-interval", protogen.DefaultContainerPollInterval, "How often a starting generator container is checked for readiness")
+	flags.StringVar(&cfg.ContainerLogPath, "container-log", "", "File to save the complete output of the generator container commands to, which is also streamed to stderr, e.g: 'git-proto-gen.log'")
+	flags.StringVar(&cfg.BufConfigsPath, "buf-configs", "", "Path to optional buf config files (buf.yaml, buf.gen.go.yaml, buf.gen.js.yaml)")
+	flags.StringVar(&cfg.ManifestPath, "manifest", protogen.DefaultManifestFileName, "Path to the project manifest declaring sources and their workspace mount points (loaded if present)")
+	flags.StringVar(&cfg.OutputFormat, "output-format", outputFormatText, "Format of error reports and command output: text, json")
+	flags.DurationVar(&cfg.Timeout, "timeout", 0, "Cancel the command after this long, terminating its containers and removing its temporary files, 0 for no limit, e.g: '15m'")
+}
 
-//go:embed buf/buf.gen.js.yaml
-var f2 embed.FS
-var bufGenJsYamlContent, _ = f2.ReadFile("buf/buf.gen.js.yaml")
+// addGenerateFlags registers the flags controlling code generation and its output.
+func addGenerateFlags(flags *pflag.FlagSet, cfg *Config) {
+	flags.StringVar(&cfg.OutputPath, "output", "events", "Output directory layout for generated files, may contain {lang}, {source} and {package} placeholders, e.g: 'gen/{lang}'")
+	flags.StringToStringVar(&cfg.LanguageOutputs, "lang-output", map[string]string{}, "Per-language output directory layout overriding --output (repeatable, comma-separated), e.g: 'go=gen/go/{package},js=gen/ts'")
+	flags.StringSliceVar(&cfg.Languages, "lang", []string{"go", "js"}, "Target language(s) for code generation: go, js (comma-separated or repeatable)")
+	flags.StringVar(&cfg.GoModule, "go-module", "", "Go module path for generated Go code; derives go_package from it and writes go.mod to the Go output directory, e.g: 'github.com/acme/events'")
+	flags.BoolVar(&cfg.VerifyGoModule, "verify-go-module", true, "Build the generated Go module inside the generator container (requires --go-module)")
+	flags.StringVar(&cfg.NpmPackage.Name, "npm-package", "", "npm package name for generated TypeScript; writes package.json and index.ts barrels and compiles to ESM and CJS, e.g: '@acme/events'")
+	flags.StringVar(&cfg.NpmPackage.Version, "npm-version", "0.0.0", "Version of the generated npm package (requires --npm-package)")
+	flags.BoolVar(&cfg.NpmPackage.Pack, "npm-pack", false, "Run npm pack to produce a tarball of the generated npm package (requires --npm-package)")
+}
 
-type GithubAuthMethodType string
+// loadManifest reads the project manifest and applies it to the options whose flags were not
+// set on the command line.
+func (cfg *Config) loadManifest(cmd *cobra.Command) (*protogen.Manifest, error) {
+	if cfg.OutputFormat != outputFormatText && cfg.OutputFormat != outputFormatJSON {
+		return nil, fmt.Errorf("invalid output format '%s'. Allowed values: text, json", cfg.OutputFormat)
+	}
 
-const (
-	GithubAuthMethodSSH   GithubAuthMethodType = "ssh"
-	GithubAuthMethodToken GithubAuthMethodType = "token"
-)
+	manifest, err := protogen.LoadManifest(cfg.ManifestPath, cmd.Flags().Changed("manifest"))
+	if err != nil {
+		return nil, err
+	}
+	// A manifest that does not exist and was not asked for loads as an empty one.
+	if _, err := os.Stat(cfg.ManifestPath); err == nil {
+		logger.Info("loaded project manifest", "path", cfg.ManifestPath, "sources", len(manifest.Sources))
+	}
 
-type Config struct {
-	LocalPath              string
-	PrivateRepos           []string
-	PublicRepos            []string
-	OutputPath             string
-	Languages              []string
-	GithubToken            string
-	GithubAuthMethod       GithubAuthMethodType
-	OptionalBufConfigsPath string
-}
+	flags := cmd.Flags()
+	cfg.HostTokens = manifest.Tokens
+	if cfg.GithubToken != "" {
+		cfg.GithubTokenSource = "--token"
+	}
+	if !flags.Changed("buf-configs") && manifest.BufConfigs != "" {
+		cfg.BufConfigsPath = manifest.BufConfigs
+	}
+	if c := manifest.Container; c != nil {
+		if !flags.Changed("image") && c.Image != "" {
+			cfg.Container.Image = c.Image
+		}
+		if !flags.Changed("buf-version") && c.BufVersion != "" {
+			cfg.Container.BufVersion = c.BufVersion
+		}
+		if !flags.Changed("container-memory") && c.Memory != "" {
+			cfg.Container.Memory = c.Memory
+		}
+		if !flags.Changed("container-cpus") && c.CPUs != 0 {
+			cfg.Container.CPUs = c.CPUs
+		}
+		if !flags.Changed("container-startup-timeout") && c.StartupTimeout != 0 {
+			cfg.Container.StartupTimeout = c.StartupTimeout
+		}
+		if !flags.Changed("container-poll-interval") && c.PollInterval != 0 {
+			cfg.Container.PollInterval = c.PollInterval
+		}
+	}
 
-func parseArgs() (*Config, error) {
-	var cfg Config
-	cmd := &cobra.Command{
-		Use:   "git-proto-gen",
-		Short: "Generate code from .proto files",
-		Long:  "A CLI tool for generating code from .proto definitions from local or remote GitHub sources.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if cfg.LocalPath == "" && len(cfg.PrivateRepos) == 0 && len(cfg.PublicRepos) == 0 {
-				return errors.New("you must provide at least one of --local, --private-repo, or --public-repo")
+	if flags.Lookup("output") != nil {
+		if !flags.Changed("lang") && len(manifest.Languages) > 0 {
+			cfg.Languages = manifest.Languages
+		}
+		if !flags.Changed("output") && manifest.Output != "" {
+			cfg.OutputPath = manifest.Output
+		}
+		for lang, layout := range manifest.Outputs {
+			if _, ok := cfg.LanguageOutputs[lang]; !ok {
+				cfg.LanguageOutputs[lang] = layout
 			}
+		}
 
-			allowed := map[string]bool{"go": true, "js": true}
-			for _, lang := range cfg.Languages {
-				if !allowed[lang] {
-					return fmt.Errorf("invalid language '%s'. Allowed values: go, js", lang)
-				}
-			}
+		if !flags.Changed("go-module") && manifest.GoModule != "" {
+			cfg.GoModule = manifest.GoModule
+		}
 
-			if len(cfg.Languages) == 0 {
-				return errors.New("you must provide at least one --lang (go, js, or both)")
+		if manifest.NpmPackage != nil {
+			if !flags.Changed("npm-package") {
+				cfg.NpmPackage.Name = manifest.NpmPackage.Name
 			}
-
-			if len(cfg.PrivateRepos) > 0 && cfg.GithubToken == "" && !checkSSHKeys() {
-				return errors.New("you must provide a GitHub token with --token for private repos or have SSH keys configured")
+			if !flags.Changed("npm-version") && manifest.NpmPackage.Version != "" {
+				cfg.NpmPackage.Version = manifest.NpmPackage.Version
 			}
-
-			if len(cfg.PrivateRepos) > 0 {
-				if cfg.GithubToken == "" {
-					cfg.GithubAuthMethod = GithubAuthMethodSSH
-				} else {
-					cfg.GithubAuthMethod = GithubAuthMethodToken
-				}
+			if !flags.Changed("npm-pack") {
+				cfg.NpmPackage.Pack = manifest.NpmPackage.Pack
 			}
+		}
+	}
 
-			return nil
-		},
+	return manifest, nil
+}
+
+// newGenerator merges the sources of the command line and the manifest and returns a
+// generator for them, streaming the output of the container commands to stderr and the
+// container log.
+func (cfg *Config) newGenerator(manifest *protogen.Manifest) (*protogen.Generator, error) {
+	cfg.Sources = append(sourcesFromFlags(cfg), manifest.Sources...)
+	if len(cfg.Sources) == 0 {
+		return nil, errors.New("you must provide at least one of --local, --private-repo, --public-repo, or sources in the manifest")
 	}
 
-	cmd.Flags().StringVar(&cfg.LocalPath, "local", "", "Path to local .proto files, e.g: './proto'")
-	cmd.Flags().StringSliceVar(&cfg.PrivateRepos, "private-repo", nil, `GitHub path(s) to private proto repos, branch name is optional (repeatable, comma-separated), e.g: "github.com/S4eed3sm/private-test-proto/proto@main"`)
-	cmd.Flags().StringSliceVar(&cfg.PublicRepos, "public-repo", nil, `GitHub path(s) to public proto repos, branch name is optional (repeatable, comma-separated), e.g: "github.com/S4eed3sm/public-test-proto/proto@dev"`)
-	cmd.Flags().StringVar(&cfg.OutputPath, "output", "events", "Output directory for generated files")
-	cmd.Flags().StringSliceVar(&cfg.Languages, "lang", []string{"go", "js"}, "Target language(s) for code generation: go, js (comma-separated or repeatable)")
-	cmd.Flags().StringVar(&cfg.OptionalBufConfigsPath, "buf-configs", "", "Path to optional buf config files (buf.yaml, buf.gen.go.yaml, buf.gen.js.yaml)")
-	cmd.Flags().StringVar(&cfg.GithubToken, "token", "", "GitHub token for private repos")
+	cfg.ContainerOutput = os.Stderr
+	if cfg.ContainerLogPath != "" {
+		f, err := os.Create(cfg.ContainerLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create container log '%s': %w", cfg.ContainerLogPath, err)
+		}
+		cfg.containerLog = f
+		cfg.ContainerOutput = io.MultiWriter(os.Stderr, f)
+	}
+	return protogen.New(cfg.Options), nil
+}
 
-	if err := cmd.Execute(); err != nil {
-		return nil, err
+// applyTimeout bounds the context of cmd by --timeout.
+func (cfg *Config) applyTimeout(cmd *cobra.Command) {
+	if cfg.Timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), cfg.Timeout)
+	cfg.cancelTimeout = cancel
+	cmd.SetContext(ctx)
+}
+
+// close releases the timeout and closes the container log.
+func (cfg *Config) close() {
+	if cfg.cancelTimeout != nil {
+		cfg.cancelTimeout()
+	}
+	if cfg.containerLog == nil {
+		return
+	}
+	if err := cfg.containerLog.Close(); err != nil {
+		logger.Warn("failed to close container log", "path", cfg.ContainerLogPath, "error", err)
+	}
+}
+
+// sourcesFromFlags converts the --local, --private-repo and --public-repo flags into sources
+// with the default mount points, or the prefix given after = for local sources.
+func sourcesFromFlags(cfg *Config) []protogen.Source {
+	var sources []protogen.Source
+	for _, p := range cfg.LocalPaths {
+		src := protogen.Source{Type: protogen.SourceTypeLocal, Path: p}
+		// The prefix follows the last =, so a path containing = keeps it when a prefix is given.
+		if i := strings.LastIndex(p, "="); i >= 0 {
+			prefix := p[i+1:]
+			src.Path, src.Mount.Prefix = p[:i], &prefix
+		}
+		sources = append(sources, src)
+	}
+	for _, p := range cfg.PrivateRepos {
+		sources = append(sources, protogen.Source{Type: protogen.SourceTypePrivate, Path: p})
+	}
+	for _, p := range cfg.PublicRepos {
+		sources = append(sources, protogen.Source{Type: protogen.SourceTypePublic, Path: p})
 	}
-	return &cfg, nil
+	return sources
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/S4eed3sm/git-proto-gen/protogen"
+	"github.com/spf13/pflag"
+)
+
+func TestSourcesFromFlags(t *testing.T) {
+	cfg := &Config{
+		LocalPaths:   []string{"./api/proto", "./internal/events/proto=events", "./vendor/key=value/proto=vendored"},
+		PrivateRepos: []string{"github.com/acme/private/proto@main"},
+		PublicRepos:  []string{"github.com/acme/public/proto"},
+	}
+	prefix, vendoredPrefix := "events", "vendored"
+	want := []protogen.Source{
+		{Type: protogen.SourceTypeLocal, Path: "./api/proto"},
+		{Type: protogen.SourceTypeLocal, Path: "./internal/events/proto", Mount: protogen.Mount{Prefix: &prefix}},
+		{Type: protogen.SourceTypeLocal, Path: "./vendor/key=value/proto", Mount: protogen.Mount{Prefix: &vendoredPrefix}},
+		{Type: protogen.SourceTypePrivate, Path: "github.com/acme/private/proto@main"},
+		{Type: protogen.SourceTypePublic, Path: "github.com/acme/public/proto"},
+	}
+
+	if got := sourcesFromFlags(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRepoFlagsKeepCommas(t *testing.T) {
+	cfg := &Config{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addSourceFlags(flags, cfg)
+	args := []string{
+		"--public-repo", "github.com/acme/public/proto@>= 1.0, < 2",
+		"--public-repo", "github.com/acme/other/proto",
+		"--private-repo", "github.com/acme/private/proto@~1.2, != 1.2.3",
+	}
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"github.com/acme/public/proto@>= 1.0, < 2", "github.com/acme/other/proto"}; !reflect.DeepEqual(cfg.PublicRepos, want) {
+		t.Errorf("got public repos %q, want %q", cfg.PublicRepos, want)
+	}
+	if want := []string{"github.com/acme/private/proto@~1.2, != 1.2.3"}; !reflect.DeepEqual(cfg.PrivateRepos, want) {
+		t.Errorf("got private repos %q, want %q", cfg.PrivateRepos, want)
+	}
+}
+
+func TestCleanLoadsManifest(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), protogen.DefaultManifestFileName)
+	manifest := "output: gen/{lang}\noutputs:\n  go: gen/go/{package}\nbuf_configs: buf\n"
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cmd := newRootCommand(cfg)
+	cmd.SetArgs([]string{"clean", "--manifest", manifestPath, "--output-format", "text"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.OutputPath != "gen/{lang}" || cfg.LanguageOutputs["go"] != "gen/go/{package}" || cfg.BufConfigsPath != "buf" {
+		t.Errorf("got output %q, language outputs %v and buf configs %q, want those of the manifest", cfg.OutputPath, cfg.LanguageOutputs, cfg.BufConfigsPath)
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	result := &protogen.CleanResult{Files: []string{"gen/go/acme/orders.pb.go"}}
+
+	var text bytes.Buffer
+	if err := printResult(&text, outputFormatText, result); err != nil {
+		t.Fatal(err)
+	}
+	if text.Len() != 0 {
+		t.Errorf("got text output %q, want none", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := printResult(&out, outputFormatJSON, result); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"result":{"files":["gen/go/acme/orders.pb.go"]}}` + "\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
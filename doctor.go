@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/S4eed3sm/git-proto-gen/protogen"
+)
+
+// printDoctorChecks writes the checks to w and returns the number of failed checks.
+func printDoctorChecks(w io.Writer, outputFormat string, checks []protogen.DoctorCheck) (int, error) {
+	failed := 0
+	for _, check := range checks {
+		if check.Status == protogen.DoctorFail {
+			failed++
+		}
+	}
+
+	if outputFormat == outputFormatJSON {
+		return failed, json.NewEncoder(w).Encode(map[string]any{"checks": checks, "failed": failed})
+	}
+
+	for _, check := range checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", strings.ToUpper(string(check.Status)), check.Name, check.Detail)
+		if check.Hint != "" && check.Status != protogen.DoctorPass {
+			fmt.Fprintf(w, "       hint: %s\n", check.Hint)
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/S4eed3sm/git-proto-gen/protogen"
+)
+
+// exitCodes are part of the CLI contract and documented in the README; never renumber them.
+// Errors without a kind are bugs or unexpected failures and use the internal exit code.
+var exitCodes = map[protogen.ErrorKind]int{
+	protogen.ErrorKindInternal:   1,
+	protogen.ErrorKindConfig:     2,
+	protogen.ErrorKindAuth:       3,
+	protogen.ErrorKindFetch:      4,
+	protogen.ErrorKindContainer:  5,
+	protogen.ErrorKindGeneration: 6,
+	protogen.ErrorKindOutput:     7,
+	protogen.ErrorKindCheck:      8,
+	protogen.ErrorKindCanceled:   9,
+}
+
+var errorHints = map[protogen.ErrorKind]string{
+	protogen.ErrorKindConfig:     "check the command line flags and the project manifest, see --help",
+	protogen.ErrorKindAuth:       "check that --token is valid and can read the repository, or that your SSH key is loaded",
+	protogen.ErrorKindFetch:      "check that the repository, path and ref (or the archive URL or OCI reference) exist and are reachable",
+	protogen.ErrorKindContainer:  "check that Docker is running and can pull the generator image (--image)",
+	protogen.ErrorKindGeneration: "check the buf output above for errors in the .proto files or buf templates",
+	protogen.ErrorKindOutput:     "check that the output directory is writable; the previous output was left in place",
+	protogen.ErrorKindCheck:      "fix the issues reported above",
+	protogen.ErrorKindCanceled:   "the command was interrupted or ran longer than --timeout; its containers and temporary files were removed",
+}
+
+func exitCodeOf(err error) int {
+	if code, ok := exitCodes[protogen.KindOf(err)]; ok {
+		return code
+	}
+	return exitCodes[protogen.ErrorKindInternal]
+}
+
+type errorEnvelope struct {
+	Error errorEnvelopeBody `json:"error"`
+}
+
+type errorEnvelopeBody struct {
+	Kind     protogen.ErrorKind `json:"kind"`
+	ExitCode int                `json:"exit_code"`
+	Message  string             `json:"message"`
+	Hint     string             `json:"hint,omitempty"`
+}
+
+// reportError writes err to w in the requested output format and returns the exit code.
+func reportError(w io.Writer, outputFormat string, err error) int {
+	kind := protogen.KindOf(err)
+	if kind == "" {
+		kind = protogen.ErrorKindInternal
+	}
+	body := errorEnvelopeBody{
+		Kind:     kind,
+		ExitCode: exitCodeOf(err),
+		Message:  err.Error(),
+		Hint:     errorHints[kind],
+	}
+
+	if outputFormat == outputFormatJSON {
+		content, _ := json.Marshal(errorEnvelope{Error: body})
+		fmt.Fprintln(w, string(content))
+		return body.ExitCode
+	}
+
+	fmt.Fprintf(w, "Error (%s): %s\n", body.Kind, body.Message)
+	if body.Hint != "" {
+		fmt.Fprintf(w, "Hint: %s\n", body.Hint)
+	}
+	return body.ExitCode
+}
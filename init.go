@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/S4eed3sm/git-proto-gen/protogen"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultBufConfigsDir = "buf"
+
+// prompter asks questions on an interactive terminal, using the default for empty answers.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && !(err == io.EOF && answer != "") {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+func (p *prompter) confirm(question string, defaultValue bool) (bool, error) {
+	hint := "y/N"
+	if defaultValue {
+		hint = "Y/n"
+	}
+	answer, err := p.ask(question+" ("+hint+")", "")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		return defaultValue, nil
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid answer '%s', expected y or n", answer)
+	}
+}
+
+func (p *prompter) askList(question string, defaultValue []string) ([]string, error) {
+	answer, err := p.ask(question, strings.Join(defaultValue, ","))
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, value := range strings.Split(answer, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// initProject is what the init command writes: a manifest and optionally editable copies of the
+// embedded buf configs.
+type initProject struct {
+	manifest       protogen.Manifest
+	writeBufConfig bool
+}
+
+// promptInitProject asks for every setting of the project, offering the values of project as
+// defaults.
+func promptInitProject(p *prompter, project *initProject) error {
+	var sources []protogen.Source
+	for _, src := range project.manifest.Sources {
+		question := fmt.Sprintf("Use %s source '%s'?", src.Type, src.Path)
+		use, err := p.confirm(question, true)
+		if err != nil {
+			return err
+		}
+		if use {
+			sources = append(sources, src)
+		}
+	}
+
+	for _, sourceType := range []protogen.SourceType{protogen.SourceTypeLocal, protogen.SourceTypePublic, protogen.SourceTypePrivate} {
+		example := "./proto"
+		if sourceType != protogen.SourceTypeLocal {
+			example = "github.com/acme/protos/proto@main"
+		}
+		paths, err := p.askList(fmt.Sprintf("Additional %s sources, comma-separated (e.g: %s)", sourceType, example), nil)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			sources = append(sources, protogen.Source{Type: sourceType, Path: path})
+		}
+	}
+	project.manifest.Sources = sources
+
+	languages, err := p.askList("Languages to generate, comma-separated (go, js)", project.manifest.Languages)
+	if err != nil {
+		return err
+	}
+	project.manifest.Languages = languages
+
+	if project.manifest.Output, err = p.ask("Output directory layout, may contain {lang}, {source} and {package}", project.manifest.Output); err != nil {
+		return err
+	}
+
+	if slices.Contains(languages, "go") {
+		if project.manifest.GoModule, err = p.ask("Go module path for generated Go code (empty for none)", project.manifest.GoModule); err != nil {
+			return err
+		}
+	}
+
+	if slices.Contains(languages, "js") {
+		defaultName := ""
+		if project.manifest.NpmPackage != nil {
+			defaultName = project.manifest.NpmPackage.Name
+		}
+		name, err := p.ask("npm package name for generated TypeScript (empty for none)", defaultName)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			project.manifest.NpmPackage = nil
+		} else {
+			project.manifest.NpmPackage = &protogen.NpmPackage{Name: name}
+		}
+	}
+
+	question := fmt.Sprintf("Write editable copies of the buf configs to '%s'?", project.manifest.BufConfigs)
+	if project.writeBufConfig, err = p.confirm(question, project.writeBufConfig); err != nil {
+		return err
+	}
+	if !project.writeBufConfig {
+		project.manifest.BufConfigs = ""
+	}
+
+	return nil
+}
+
+// validateInitProject checks the project with the same rules the generate command applies.
+func validateInitProject(project *initProject) error {
+	if len(project.manifest.Sources) == 0 {
+		return errors.New("no .proto files found below the current directory, pass --local, --public-repo or --private-repo")
+	}
+	opts := protogen.Options{
+		Sources:         project.manifest.Sources,
+		Languages:       project.manifest.Languages,
+		OutputPath:      project.manifest.Output,
+		LanguageOutputs: project.manifest.Outputs,
+		GoModule:        project.manifest.GoModule,
+	}
+	if project.manifest.NpmPackage != nil {
+		opts.NpmPackage = *project.manifest.NpmPackage
+	}
+	return opts.Validate()
+}
+
+// renderManifest encodes the manifest of a new project, leaving out empty settings.
+func renderManifest(manifest *protogen.Manifest) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# git-proto-gen project manifest, see the Project Manifest section of the README.\n")
+	buf.WriteString("# Command line flags take precedence over the settings below.\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeInitProject writes the manifest and buf configs of project. Nothing is written when one of
+// the files exists and force is not set.
+func writeInitProject(manifestPath string, project *initProject, force bool) error {
+	files := map[string][]byte{}
+
+	content, err := renderManifest(&project.manifest)
+	if err != nil {
+		return err
+	}
+	files[manifestPath] = content
+
+	if project.writeBufConfig {
+		for name, content := range protogen.DefaultBufConfigs() {
+			files[filepath.Join(project.manifest.BufConfigs, name)] = content
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	if !force {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				return protogen.WithKind(protogen.ErrorKindConfig, fmt.Errorf("'%s' already exists, use --force to overwrite it", path))
+			}
+		}
+	}
+
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, files[path], 0644); err != nil {
+			return fmt.Errorf("failed to write '%s': %w", path, err)
+		}
+		logger.Info("created file", "path", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package protogen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveDownloadTimeout bounds the download of an archive source, including reading its body.
+const archiveDownloadTimeout = 5 * time.Minute
+
+// maxArchiveProtoSize is the largest .proto file extracted from an archive. Larger entries are
+// rejected rather than filling the disk with a decompression bomb.
+const maxArchiveProtoSize = 16 << 20
+
+var sha256Re = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// isArchiveURL reports whether the path of an archive source is downloaded rather than read from
+// the local file system.
+func isArchiveURL(p string) bool {
+	return strings.HasPrefix(p, "https://") || strings.HasPrefix(p, "http://")
+}
+
+// archiveName returns the default name of an archive source: its file name without the archive
+// extension, e.g. "acme-protos-1.2.0" for "https://example.com/acme-protos-1.2.0.tar.gz".
+func archiveName(p string) string {
+	if u, err := url.Parse(p); err == nil && isArchiveURL(p) {
+		p = u.Path
+	}
+	name := path.Base(filepath.ToSlash(p))
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// fetchArchive downloads or reads the archive of src, verifies its SHA-256 and extracts the .proto
+// files below its subdir that its filter selects into dstDir.
+func fetchArchive(ctx context.Context, logger *slog.Logger, src *Source, dstDir string) error {
+	archiveFile, err := os.CreateTemp("", "protoArchive")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for archive: %w", err)
+	}
+	defer os.Remove(archiveFile.Name())
+	defer archiveFile.Close()
+
+	hash := sha256.New()
+	if err := readArchive(ctx, logger, src.Path, io.MultiWriter(archiveFile, hash)); err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(sum, src.SHA256) {
+		return WithKind(ErrorKindFetch, fmt.Errorf("checksum mismatch for archive '%s': expected sha256 %s, got %s", src.Path, strings.ToLower(src.SHA256), sum))
+	}
+	logger.Info("verified archive checksum", "source", src.Name, "path", src.Path, "sha256", sum)
+
+	if _, err := archiveFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind archive: %w", err)
+	}
+	info, err := archiveFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	extracted, err := extractArchive(archiveFile, info.Size(), strings.Trim(src.Subdir, "/"), src.filter(), dstDir)
+	if err != nil {
+		return fmt.Errorf("failed to extract archive '%s': %w", src.Path, err)
+	}
+	logger.Info("extracted archive", "source", src.Name, "subdir", src.Subdir, "files", extracted)
+	return nil
+}
+
+// readArchive copies the archive at p, a URL or a local path, to w.
+func readArchive(ctx context.Context, logger *slog.Logger, p string, w io.Writer) error {
+	if !isArchiveURL(p) {
+		file, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to open archive '%s': %w", p, err)
+		}
+		defer file.Close()
+		if _, err := io.Copy(w, file); err != nil {
+			return fmt.Errorf("failed to read archive '%s': %w", p, err)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, archiveDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return fmt.Errorf("invalid archive URL '%s': %w", p, err)
+	}
+	logger.Info("downloading archive", "url", req.URL.Redacted())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return WithKind(ErrorKindFetch, fmt.Errorf("failed to download archive '%s': %w", req.URL.Redacted(), err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return WithKind(ErrorKindFetch, fmt.Errorf("failed to download archive '%s': %s", req.URL.Redacted(), resp.Status))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return WithKind(ErrorKindFetch, fmt.Errorf("failed to download archive '%s': %w", req.URL.Redacted(), err))
+	}
+	return nil
+}
+
+// extractArchive extracts the .proto files below subdir of a zip, tar or gzip-compressed tar
+// archive into dstDir, keeping their paths relative to subdir. The format is detected from the
+// content, as URLs of release assets do not always end in the archive extension.
+func extractArchive(r io.ReaderAt, size int64, subdir string, filter fileFilter, dstDir string) (int, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	ex := &archiveExtractor{subdir: subdir, filter: filter, dstDir: dstDir}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return ex.zip(r, size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		return ex.tar(gz)
+	default:
+		return ex.tar(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+	}
+}
+
+type archiveExtractor struct {
+	subdir string
+	filter fileFilter
+	dstDir string
+	count  int
+}
+
+func (ex *archiveExtractor) zip(r io.ReaderAt, size int64) (int, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return ex.count, fmt.Errorf("failed to open '%s': %w", f.Name, err)
+		}
+		err = ex.extract(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return ex.count, err
+		}
+	}
+	return ex.count, nil
+}
+
+func (ex *archiveExtractor) tar(r io.Reader) (int, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return ex.count, nil
+		}
+		if err != nil {
+			return ex.count, err
+		}
+		// Links and devices are skipped, so an entry can never point outside the workspace.
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := ex.extract(header.Name, tr); err != nil {
+			return ex.count, err
+		}
+	}
+}
+
+// extract writes the archive entry name to dstDir if it is a selected .proto file below subdir.
+func (ex *archiveExtractor) extract(name string, r io.Reader) error {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	if path.IsAbs(name) || slices.Contains(strings.Split(name, "/"), "..") {
+		return fmt.Errorf("archive entry '%s' points outside the archive", name)
+	}
+	if !strings.HasSuffix(name, ".proto") {
+		return nil
+	}
+
+	rel := path.Clean(name)
+	if ex.subdir != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, ex.subdir+"/"); !ok {
+			return nil
+		}
+	}
+	if !ex.filter.match(rel) {
+		return nil
+	}
+
+	targetPath := filepath.Join(ex.dstDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(targetPath), err)
+	}
+	file, err := os.Create(targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file '%s': %w", targetPath, err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(r, maxArchiveProtoSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to extract '%s': %w", name, err)
+	}
+	if n > maxArchiveProtoSize {
+		return fmt.Errorf("archive entry '%s' is larger than %d MiB", name, maxArchiveProtoSize>>20)
+	}
+
+	ex.count++
+	return nil
+}
@@ -0,0 +1,171 @@
+package protogen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// archiveEntries are the files of the test archives, in the directory layout of a release asset.
+var archiveEntries = map[string]string{
+	"acme-protos-1.2.0/proto/acme/orders.proto":   "orders",
+	"acme-protos-1.2.0/proto/acme/v1/users.proto": "users",
+	"acme-protos-1.2.0/README.md":                 "readme",
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serveArchive serves content at /<name> and returns its URL and SHA-256.
+func serveArchive(t *testing.T, name string, content []byte) (string, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	sum := sha256.Sum256(content)
+	return server.URL + "/" + name, hex.EncodeToString(sum[:])
+}
+
+func fetchArchiveSource(t *testing.T, src Source) (string, error) {
+	t.Helper()
+	src.Type = SourceTypeArchive
+	dir := t.TempDir()
+	_, err := New(Options{Sources: []Source{src}}).Fetch(context.Background(), dir)
+	return dir, err
+}
+
+func TestArchiveFetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive func(*testing.T, map[string]string) []byte
+		file    string
+		subdir  string
+		want    map[string]string
+	}{
+		{
+			name:    "tar.gz",
+			archive: tarGzArchive,
+			file:    "acme-protos-1.2.0.tar.gz",
+			want: map[string]string{
+				"acme-protos-1.2.0/proto/acme/orders.proto":   "orders",
+				"acme-protos-1.2.0/proto/acme/v1/users.proto": "users",
+			},
+		},
+		{
+			name:    "zip",
+			archive: zipArchive,
+			file:    "acme-protos-1.2.0.zip",
+			want: map[string]string{
+				"acme-protos-1.2.0/proto/acme/orders.proto":   "orders",
+				"acme-protos-1.2.0/proto/acme/v1/users.proto": "users",
+			},
+		},
+		{
+			name:    "tar.gz subdir",
+			archive: tarGzArchive,
+			file:    "acme-protos-1.2.0.tar.gz",
+			subdir:  "acme-protos-1.2.0/proto",
+			want:    map[string]string{"acme/orders.proto": "orders", "acme/v1/users.proto": "users"},
+		},
+		{
+			name:    "zip subdir",
+			archive: zipArchive,
+			file:    "acme-protos-1.2.0.zip",
+			subdir:  "acme-protos-1.2.0/proto/acme/v1/",
+			want:    map[string]string{"users.proto": "users"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, sum := serveArchive(t, tt.file, tt.archive(t, archiveEntries))
+			dir, err := fetchArchiveSource(t, Source{Path: url, SHA256: sum, Subdir: tt.subdir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dir, tt.want)
+		})
+	}
+}
+
+func TestArchiveFetchRejectsChecksumMismatch(t *testing.T) {
+	url, _ := serveArchive(t, "acme-protos-1.2.0.tar.gz", tarGzArchive(t, archiveEntries))
+	wrongSum := strings.Repeat("0", 64)
+
+	dir, err := fetchArchiveSource(t, Source{Path: url, SHA256: wrongSum})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("got error %v, want a checksum mismatch", err)
+	}
+	if KindOf(err) != ErrorKindFetch {
+		t.Errorf("got error kind %q, want %q", KindOf(err), ErrorKindFetch)
+	}
+	assertTree(t, dir, map[string]string{})
+}
+
+func TestArchiveFetchRejectsPathTraversal(t *testing.T) {
+	entries := []string{"../escaped.proto", "acme/../../escaped.proto", "/etc/escaped.proto"}
+	for _, entry := range entries {
+		for name, archive := range map[string]func(*testing.T, map[string]string) []byte{"tar.gz": tarGzArchive, "zip": zipArchive} {
+			t.Run(name+" "+entry, func(t *testing.T) {
+				url, sum := serveArchive(t, "acme-protos."+name, archive(t, map[string]string{entry: "escaped"}))
+				dir, err := fetchArchiveSource(t, Source{Path: url, SHA256: sum})
+				if err == nil || !strings.Contains(err.Error(), "points outside the archive") {
+					t.Fatalf("got error %v, want an entry outside the archive to be rejected", err)
+				}
+				assertTree(t, dir, map[string]string{})
+			})
+		}
+	}
+}
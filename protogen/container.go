@@ -0,0 +1,344 @@
+package protogen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultBufVersion is the buf version of the default generator image.
+	DefaultBufVersion = "1.54.0"
+	// DefaultContainerMemory is the memory limit of the generator container, swap included.
+	DefaultContainerMemory = "2g"
+	// DefaultContainerStartupTimeout is how long the generator container may take to start.
+	DefaultContainerStartupTimeout = 120 * time.Second
+	// DefaultContainerPollInterval is how often a starting generator container is checked.
+	DefaultContainerPollInterval = 5 * time.Second
+
+	bufImageRepository = "bufbuild/buf"
+	// containerTerminateTimeout bounds terminating a container, which also happens after the
+	// context of the command was canceled.
+	containerTerminateTimeout = 30 * time.Second
+	// minBufVersionV2 is the first buf release reading buf.yaml and buf.gen.yaml files with
+	// version: v2.
+	minBufVersionV2 = "1.32.0"
+)
+
+// ContainerOptions configures the container buf runs in.
+type ContainerOptions struct {
+	// Image is the generator image by tag or digest, e.g. "bufbuild/buf:1.50.0" or
+	// "registry.acme.com/buf@sha256:...". Defaults to bufbuild/buf at BufVersion.
+	Image string `yaml:"image,omitempty"`
+	// BufVersion is the buf version of the image. It selects the tag of the default image, and
+	// declares the version of a custom one. Defaults to DefaultBufVersion for the default image;
+	// for a custom image without it, the version is read from the container.
+	BufVersion string `yaml:"buf_version,omitempty"`
+	// Memory limits the memory of the container, swap included, e.g. "4g" or "512m". "0" removes
+	// the limit.
+	Memory string `yaml:"memory,omitempty"`
+	// CPUs limits the number of CPUs the container may use, e.g. 1.5. 0 removes the limit.
+	CPUs float64 `yaml:"cpus,omitempty"`
+	// StartupTimeout is how long the container may take to start, e.g. "3m".
+	StartupTimeout time.Duration `yaml:"startup_timeout,omitempty"`
+	// PollInterval is how often a starting container is checked for readiness.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+}
+
+// withDefaults fills in the defaults of the options left empty.
+func (o *ContainerOptions) withDefaults() {
+	if o.Image == "" {
+		if o.BufVersion == "" {
+			o.BufVersion = DefaultBufVersion
+		}
+		o.Image = bufImageRepository + ":" + o.BufVersion
+	}
+	if o.Memory == "" {
+		o.Memory = DefaultContainerMemory
+	}
+	if o.StartupTimeout == 0 {
+		o.StartupTimeout = DefaultContainerStartupTimeout
+	}
+	if o.PollInterval == 0 {
+		o.PollInterval = DefaultContainerPollInterval
+	}
+}
+
+// bufVersion returns the declared buf version of the image: BufVersion, or else the tag of a
+// bufbuild/buf image. It is empty when the version is only known once the container runs.
+func (o ContainerOptions) bufVersion() string {
+	if o.BufVersion != "" {
+		return o.BufVersion
+	}
+	repository, tag := splitImageTag(o.Image)
+	if tag != "" && isBufImage(repository) {
+		return tag
+	}
+	return ""
+}
+
+// splitImageTag returns the repository and the tag of an image reference. The tag is empty
+// for references by digest or without a tag.
+func splitImageTag(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+// validate checks the limits and timeouts, and that the declared buf version reads the buf
+// configs.
+func (o ContainerOptions) validate(configs bufConfigs) error {
+	if _, err := o.memoryBytes(); err != nil {
+		return err
+	}
+	if o.CPUs < 0 {
+		return fmt.Errorf("invalid container CPU limit '%g': must not be negative", o.CPUs)
+	}
+	if o.StartupTimeout < 0 || o.PollInterval < 0 {
+		return errors.New("container startup timeout and poll interval must not be negative")
+	}
+
+	repository, tag := splitImageTag(o.Image)
+	if o.BufVersion != "" && tag != "" && isBufImage(repository) && strings.TrimPrefix(tag, "v") != strings.TrimPrefix(o.BufVersion, "v") {
+		return fmt.Errorf("buf version '%s' does not match the tag of image '%s'", o.BufVersion, o.Image)
+	}
+
+	if version := o.bufVersion(); version != "" {
+		return checkBufVersion(version, configs)
+	}
+	return nil
+}
+
+// isBufImage reports whether repository is the bufbuild/buf one, whose tags are buf versions.
+func isBufImage(repository string) bool {
+	return repository == bufImageRepository || repository == "docker.io/"+bufImageRepository
+}
+
+// memoryBytes returns the memory limit in bytes, 0 for none.
+func (o ContainerOptions) memoryBytes() (int64, error) {
+	if o.Memory == "" || o.Memory == "0" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(o.Memory)
+	if err != nil || bytes <= 0 {
+		return 0, fmt.Errorf("invalid container memory limit '%s', e.g: '2g' or '512m'", o.Memory)
+	}
+	return bytes, nil
+}
+
+// checkBufVersion checks that buf at version reads the buf configs, which need at least
+// minBufVersionV2 when they declare version: v2.
+func checkBufVersion(version string, configs bufConfigs) error {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid buf version '%s': %w", version, err)
+	}
+	if !v.LessThan(semver.MustParse(minBufVersionV2)) {
+		return nil
+	}
+
+	names := []string{bufYamlFileName, bufGenGoYamlFileName, bufGenJsYamlFileName}
+	for i, content := range [][]byte{configs.yaml, configs.genGo, configs.genJs} {
+		var config struct {
+			Version string `yaml:"version"`
+		}
+		if yaml.Unmarshal(content, &config) == nil && config.Version == "v2" {
+			return fmt.Errorf("buf %s does not support the 'version: v2' syntax of %s, use buf %s or later", version, names[i], minBufVersionV2)
+		}
+	}
+	return nil
+}
+
+var bufVersionRe = regexp.MustCompile(`\d+\.\d+\.\d+\S*`)
+
+// checkContainerBufVersion reads the buf version of a running container whose image does not
+// declare it and checks it against the buf configs.
+func checkContainerBufVersion(ctx context.Context, c *generatorContainer, opts ContainerOptions, configs bufConfigs) error {
+	if opts.bufVersion() != "" {
+		return nil
+	}
+
+	output, err := execInContainerOutput(ctx, c, "read buf version", []string{"buf", "--version"})
+	if err != nil {
+		return WithKind(ErrorKindContainer, err)
+	}
+	version := bufVersionRe.FindString(output)
+	if version == "" {
+		return WithKind(ErrorKindContainer, fmt.Errorf("failed to read the buf version of image '%s' from '%s'", opts.Image, strings.TrimSpace(output)))
+	}
+	c.logger.Info("read buf version of generator image", "image", opts.Image, "version", version)
+
+	if err := checkBufVersion(version, configs); err != nil {
+		return WithKind(ErrorKindConfig, fmt.Errorf("image '%s': %w", opts.Image, err))
+	}
+	return nil
+}
+
+// generatorContainer is a running generator container. The output of the commands executed in
+// it is streamed to output, every line prefixed with label and the step it belongs to.
+type generatorContainer struct {
+	testcontainers.Container
+	label         string
+	output        io.Writer
+	logPath       string
+	logger        *slog.Logger
+	terminateOnce sync.Once
+}
+
+// startGeneratorContainer starts a generator container that stays idle until commands are
+// executed in it. The workspace is mounted at /workspace and the generated output directory at
+// /workspace/temp_generated_output; extraBinds are added as they are, in "host:container" form.
+// label names what the container is used for in the streamed output, e.g. the language. The
+// container is terminated as soon as ctx is canceled, which also ends the commands running in it.
+func startGeneratorContainer(ctx context.Context, cfg *Options, label string, ws *workspace, extraBinds ...string) (*generatorContainer, error) {
+	opts := cfg.Container
+	memory, err := opts.memoryBytes()
+	if err != nil {
+		return nil, WithKind(ErrorKindConfig, err)
+	}
+
+	containerReq := testcontainers.ContainerRequest{
+		Image:      opts.Image,
+		WorkingDir: "/workspace",
+		Entrypoint: []string{"sh"},
+		Cmd:        []string{"-c", "tail -f /dev/null"},
+		WaitingFor: wait.ForExec([]string{"echo", "ready"}).
+			WithStartupTimeout(opts.StartupTimeout).
+			WithPollInterval(opts.PollInterval),
+		HostConfigModifier: func(hostConfig *container.HostConfig) {
+			hostConfig.Binds = append([]string{
+				fmt.Sprintf("%s:%s", ws.dir, "/workspace"),
+				fmt.Sprintf("%s:%s", ws.generatedDir, "/workspace/temp_generated_output"),
+			}, extraBinds...)
+			hostConfig.Memory = memory
+			hostConfig.MemorySwap = memory
+			hostConfig.NanoCPUs = int64(opts.CPUs * 1e9)
+		},
+	}
+
+	tc, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: containerReq,
+		Started:          true, // Start the container immediately
+	})
+	if err != nil {
+		// A container that was created but failed to start is returned as well.
+		if tc != nil {
+			(&generatorContainer{Container: tc, logger: cfg.logger()}).terminate()
+		}
+		return nil, WithKind(ErrorKindContainer, fmt.Errorf("failed to start container from image '%s': %w", opts.Image, err))
+	}
+	c := &generatorContainer{Container: tc, label: label, output: cfg.ContainerOutput, logPath: cfg.ContainerLogPath, logger: cfg.logger()}
+	context.AfterFunc(ctx, c.terminate)
+
+	if err := checkContainerBufVersion(ctx, c, opts, cfg.bufConfigs); err != nil {
+		c.terminate()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// terminate terminates the container. Only the first call does, so it is both deferred and
+// run when the context of the container is canceled.
+func (c *generatorContainer) terminate() {
+	c.terminateOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), containerTerminateTimeout)
+		defer cancel()
+
+		// Commands run as root, so the files they wrote to the bind mounts are made removable
+		// for the user removing the workspace.
+		if _, _, err := c.Exec(ctx, []string{"chmod", "-R", "a+rwX", "/workspace"}); err != nil {
+			c.logger.Debug("failed to make container files removable", "container", c.GetContainerID(), "error", err)
+		}
+		if err := c.Terminate(ctx); err != nil {
+			c.logger.Warn("failed to terminate container", "container", c.GetContainerID(), "error", err)
+		}
+	})
+}
+
+// execInContainer runs cmd in the generator container and returns an error containing the
+// command output when it fails. step names the command in errors and logs. Errors of a command
+// exiting with a non-zero status have no kind, callers decide whether it is a container or a
+// generation failure.
+func execInContainer(ctx context.Context, c *generatorContainer, step string, cmd []string) error {
+	_, err := execInContainerOutput(ctx, c, step, cmd)
+	return err
+}
+
+// execInContainerOutput is execInContainer returning the output of a successful command. The
+// output is streamed to the output of the container while the command runs.
+func execInContainerOutput(ctx context.Context, c *generatorContainer, step string, cmd []string) (string, error) {
+	c.logger.Info("running container step", "label", c.label, "step", step)
+
+	// stdout and stderr are kept in one buffer, in the order they were written, for errors.
+	var output bytes.Buffer
+	stdout, stderr := io.Writer(&output), io.Writer(&output)
+	if c.output != nil {
+		prefix := "[" + c.label + " " + step + "] "
+		streamedStdout := &prefixWriter{w: c.output, prefix: prefix}
+		streamedStderr := &prefixWriter{w: c.output, prefix: prefix}
+		defer streamedStdout.Flush()
+		defer streamedStderr.Flush()
+		stdout, stderr = io.MultiWriter(&output, streamedStdout), io.MultiWriter(&output, streamedStderr)
+	}
+
+	exitCode, reader, err := c.Exec(ctx, cmd, streamOutput(stdout, stderr))
+	if err != nil {
+		return "", WithKind(ErrorKindContainer, fmt.Errorf("failed to %s in container: %w", step, err))
+	}
+
+	if _, err := io.ReadAll(reader); err != nil {
+		return "", WithKind(ErrorKindContainer, fmt.Errorf("failed to read output of %s: %w", step, err))
+	}
+
+	if exitCode != 0 {
+		return "", fmt.Errorf("failed to %s, exit code: %d, %s", step, exitCode, c.failedOutput(output.String()))
+	}
+
+	return output.String(), nil
+}
+
+// containerErrorOutputLines is the number of lines of the output of a failed container command
+// kept in its error. The complete output is streamed to ContainerOutput.
+const containerErrorOutputLines = 20
+
+// failedOutput describes the output of a failed command for its error: the output itself when it
+// is short, otherwise its last lines and where to find the rest.
+func (c *generatorContainer) failedOutput(output string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) <= containerErrorOutputLines {
+		if c.logPath != "" {
+			return fmt.Sprintf("output (also saved to '%s'): %s", c.logPath, output)
+		}
+		return "output: " + output
+	}
+
+	tail := strings.Join(lines[len(lines)-containerErrorOutputLines:], "\n")
+	switch {
+	case c.logPath != "":
+		return fmt.Sprintf("last %d of %d lines of output, see '%s' for the complete output: %s", containerErrorOutputLines, len(lines), c.logPath, tail)
+	case c.output != nil:
+		return fmt.Sprintf("last %d of %d lines of output, see the complete output streamed above: %s", containerErrorOutputLines, len(lines), tail)
+	default:
+		return fmt.Sprintf("last %d of %d lines of output: %s", containerErrorOutputLines, len(lines), tail)
+	}
+}
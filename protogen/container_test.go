@@ -0,0 +1,127 @@
+package protogen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerOptionsDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        ContainerOptions
+		image       string
+		bufVersion  string
+		wantVersion string
+	}{
+		{name: "default", image: "bufbuild/buf:" + DefaultBufVersion, wantVersion: DefaultBufVersion},
+		{name: "buf version", opts: ContainerOptions{BufVersion: "1.50.0"}, image: "bufbuild/buf:1.50.0", wantVersion: "1.50.0"},
+		{name: "buf image tag", opts: ContainerOptions{Image: "docker.io/bufbuild/buf:1.45.0"}, image: "docker.io/bufbuild/buf:1.45.0", wantVersion: "1.45.0"},
+		{name: "digest", opts: ContainerOptions{Image: "bufbuild/buf@sha256:0123"}, image: "bufbuild/buf@sha256:0123"},
+		{name: "custom image", opts: ContainerOptions{Image: "registry.acme.com:5000/tools/buf:stable"}, image: "registry.acme.com:5000/tools/buf:stable"},
+		{name: "custom image with buf version", opts: ContainerOptions{Image: "registry.acme.com/buf:stable", BufVersion: "1.40.0"}, image: "registry.acme.com/buf:stable", wantVersion: "1.40.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.withDefaults()
+			if opts.Image != tt.image || opts.bufVersion() != tt.wantVersion {
+				t.Errorf("got image %q with buf version %q, want %q with %q", opts.Image, opts.bufVersion(), tt.image, tt.wantVersion)
+			}
+			if opts.Memory != DefaultContainerMemory || opts.StartupTimeout != DefaultContainerStartupTimeout || opts.PollInterval != DefaultContainerPollInterval {
+				t.Errorf("got limits %q, %v, %v, want the defaults", opts.Memory, opts.StartupTimeout, opts.PollInterval)
+			}
+		})
+	}
+}
+
+func TestContainerOptionsValidate(t *testing.T) {
+	v1 := bufConfigs{yaml: []byte("version: v1\n"), genGo: []byte("version: v1\n"), genJs: []byte("version: v1\n")}
+	v2 := bufConfigs{yaml: bufYamlContent, genGo: bufGenGoYamlContent, genJs: bufGenJsYamlContent}
+
+	tests := []struct {
+		name    string
+		opts    ContainerOptions
+		configs bufConfigs
+		wantErr bool
+	}{
+		{name: "defaults", configs: v2},
+		{name: "v2 templates with buf 1.32", opts: ContainerOptions{BufVersion: "1.32.0"}, configs: v2},
+		{name: "v2 templates with old buf", opts: ContainerOptions{BufVersion: "1.31.0"}, configs: v2, wantErr: true},
+		{name: "v2 templates with old buf image", opts: ContainerOptions{Image: "bufbuild/buf:1.28.1"}, configs: v2, wantErr: true},
+		{name: "v1 templates with old buf", opts: ContainerOptions{BufVersion: "1.28.1"}, configs: v1},
+		{name: "v2 templates with unknown version", opts: ContainerOptions{Image: "registry.acme.com/buf@sha256:0123"}, configs: v2},
+		{name: "buf version not matching image tag", opts: ContainerOptions{Image: "bufbuild/buf:1.50.0", BufVersion: "1.54.0"}, configs: v2, wantErr: true},
+		{name: "invalid buf version", opts: ContainerOptions{BufVersion: "latest"}, configs: v2, wantErr: true},
+		{name: "memory", opts: ContainerOptions{Memory: "512m"}, configs: v2},
+		{name: "no memory limit", opts: ContainerOptions{Memory: "0"}, configs: v2},
+		{name: "invalid memory", opts: ContainerOptions{Memory: "lots"}, configs: v2, wantErr: true},
+		{name: "negative cpus", opts: ContainerOptions{CPUs: -1}, configs: v2, wantErr: true},
+		{name: "negative timeout", opts: ContainerOptions{StartupTimeout: -time.Second}, configs: v2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.withDefaults()
+			if err := opts.validate(tt.configs); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFailedOutput(t *testing.T) {
+	var long []string
+	for i := 1; i <= 30; i++ {
+		long = append(long, fmt.Sprintf("line %d", i))
+	}
+	longOutput := strings.Join(long, "\n") + "\n"
+	tail := strings.Join(long[10:], "\n")
+
+	tests := []struct {
+		name      string
+		container *generatorContainer
+		output    string
+		want      string
+	}{
+		{
+			name:      "short output",
+			container: &generatorContainer{output: io.Discard},
+			output:    "buf: no such file\n",
+			want:      "output: buf: no such file\n",
+		},
+		{
+			name:      "short output saved to a log",
+			container: &generatorContainer{output: io.Discard, logPath: "git-proto-gen.log"},
+			output:    "buf: no such file\n",
+			want:      "output (also saved to 'git-proto-gen.log'): buf: no such file\n",
+		},
+		{
+			name:      "long output saved to a log",
+			container: &generatorContainer{output: io.Discard, logPath: "git-proto-gen.log"},
+			output:    longOutput,
+			want:      "last 20 of 30 lines of output, see 'git-proto-gen.log' for the complete output: " + tail,
+		},
+		{
+			name:      "long output streamed",
+			container: &generatorContainer{output: io.Discard},
+			output:    longOutput,
+			want:      "last 20 of 30 lines of output, see the complete output streamed above: " + tail,
+		},
+		{
+			name:      "long output discarded",
+			container: &generatorContainer{},
+			output:    longOutput,
+			want:      "last 20 of 30 lines of output: " + tail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.container.failedOutput(tt.output); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
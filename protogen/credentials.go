@@ -0,0 +1,166 @@
+package protogen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// githubHostFor returns the host credentials are looked up for when using the GitHub API at
+// apiURL: github.com for api.github.com, and the host of a GitHub Enterprise Server otherwise,
+// e.g. "github.acme.com" for "https://github.acme.com/api/v3/" or "https://api.github.acme.com/".
+func githubHostFor(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if apiURL == "" || err != nil || u.Hostname() == "" {
+		return "github.com"
+	}
+	return strings.TrimPrefix(u.Hostname(), "api.")
+}
+
+// credentialHelperTimeout bounds `git credential fill`, which may run helpers that wait for input.
+const credentialHelperTimeout = 10 * time.Second
+
+// tokenLookup is one place a GitHub token may be found. It returns "" when it has no token.
+type tokenLookup struct {
+	source string
+	lookup func(ctx context.Context, host string) (string, error)
+}
+
+// discoverGithubToken looks for a token for host in, in order: the environment, the per-host
+// tokens of the manifest, ~/.netrc and the git credential helpers. It returns the token and a
+// description of where it was found, which is safe to log.
+func discoverGithubToken(ctx context.Context, logger *slog.Logger, host string, manifestTokens map[string]string) (token, source string, err error) {
+	lookups := []tokenLookup{
+		{source: "GITHUB_TOKEN", lookup: envToken("GITHUB_TOKEN")},
+		{source: "GH_TOKEN", lookup: envToken("GH_TOKEN")},
+		{source: "manifest tokens", lookup: func(_ context.Context, host string) (string, error) {
+			return os.ExpandEnv(manifestTokens[host]), nil
+		}},
+		{source: "netrc", lookup: func(_ context.Context, host string) (string, error) {
+			return netrcToken(netrcPath(), host)
+		}},
+		{source: "git credential helper", lookup: func(ctx context.Context, host string) (string, error) {
+			return gitCredentialToken(ctx, logger, host)
+		}},
+	}
+
+	for _, l := range lookups {
+		token, err := l.lookup(ctx, host)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read GitHub credentials from %s: %w", l.source, err)
+		}
+		if token != "" {
+			return token, l.source, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+func envToken(name string) func(context.Context, string) (string, error) {
+	return func(context.Context, string) (string, error) {
+		return strings.TrimSpace(os.Getenv(name)), nil
+	}
+}
+
+// netrcPath returns the netrc file curl and git read: $NETRC, or ~/.netrc.
+func netrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".netrc")
+}
+
+// netrcToken returns the password of the netrc entry for host or api.<host>, falling back to the
+// default entry. A missing file has no token.
+func netrcToken(path, host string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var machine, defaultPassword string
+	passwords := map[string]string{}
+	fields := strings.Fields(string(content))
+parse:
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				machine = fields[i]
+			}
+		case "default":
+			machine = ""
+		case "password":
+			if i+1 < len(fields) {
+				i++
+				if machine == "" {
+					defaultPassword = fields[i]
+				} else if _, ok := passwords[machine]; !ok {
+					passwords[machine] = fields[i]
+				}
+			}
+		case "macdef":
+			// Macros are only allowed after the entries, and their body is not tokenized.
+			break parse
+		}
+	}
+
+	return firstNonEmpty(passwords[host], passwords["api."+host], defaultPassword), nil
+}
+
+// gitCredentialToken asks the configured git credential helpers for a token for host. git is not
+// allowed to prompt, so hosts without stored credentials have no token.
+func gitCredentialToken(ctx context.Context, logger *slog.Logger, host string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialHelperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// git fails when no helper has credentials and it may not prompt.
+		logger.Debug("git credential helper returned no credentials", "host", host, "error", err)
+		return "", nil
+	}
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if password, ok := strings.CutPrefix(line, "password="); ok {
+			return strings.TrimSpace(password), nil
+		}
+	}
+	return "", nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
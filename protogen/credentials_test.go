@@ -0,0 +1,42 @@
+package protogen
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveGithubTokenForAPIHost(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	writeFiles(t, filepath.Dir(netrc), map[string]string{
+		".netrc": "machine github.com login octocat password ghp_public\n" +
+			"machine github.acme.com login octocat password ghp_acme\n",
+	})
+	t.Setenv("NETRC", netrc)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+
+	tests := []struct {
+		apiURL, wantHost, wantToken string
+	}{
+		{apiURL: "", wantHost: "github.com", wantToken: "ghp_public"},
+		{apiURL: DefaultGithubAPIURL, wantHost: "github.com", wantToken: "ghp_public"},
+		{apiURL: "https://github.acme.com/api/v3/", wantHost: "github.acme.com", wantToken: "ghp_acme"},
+		{apiURL: "https://api.github.acme.com/", wantHost: "github.acme.com", wantToken: "ghp_acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiURL, func(t *testing.T) {
+			if got := githubHostFor(tt.apiURL); got != tt.wantHost {
+				t.Errorf("got host %q, want %q", got, tt.wantHost)
+			}
+
+			cfg := &Options{GithubAPIURL: tt.apiURL}
+			if err := cfg.resolveGithubToken(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.GithubToken != tt.wantToken || cfg.GithubTokenSource != "netrc" {
+				t.Errorf("got token %q from %q, want %q from netrc", cfg.GithubToken, cfg.GithubTokenSource, tt.wantToken)
+			}
+		})
+	}
+}
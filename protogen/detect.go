@@ -0,0 +1,57 @@
+package protogen
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// protoRootDirNames are directory names conventionally used as the root of a proto tree.
+var protoRootDirNames = []string{"proto", "protos", "protobuf"}
+
+// skippedScanDirs are never searched for .proto files.
+var skippedScanDirs = []string{"node_modules", "vendor", "third_party"}
+
+// DetectProtoDirs returns the local directories below root that look like proto roots: the closest
+// ancestor of each .proto file named like protoRootDirNames, or else its top-level directory.
+// Hidden directories, dependency directories and skip are not searched.
+func DetectProtoDirs(root string, skip ...string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || slices.Contains(skippedScanDirs, d.Name()) || slices.Contains(skip, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".proto" || filepath.Dir(rel) == "." {
+			return nil
+		}
+
+		segments := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+		protoRoot := segments[0]
+		for i := len(segments) - 1; i >= 0; i-- {
+			if slices.Contains(protoRootDirNames, segments[i]) {
+				protoRoot = filepath.Join(segments[:i+1]...)
+				break
+			}
+		}
+		dirs = append(dirs, protoRoot)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for .proto files in '%s': %w", root, err)
+	}
+
+	return outermostDirs(dirs), nil
+}
@@ -0,0 +1,273 @@
+package protogen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/oauth2"
+)
+
+// DoctorStatus is the outcome of a doctor check.
+type DoctorStatus string
+
+const (
+	DoctorPass DoctorStatus = "pass"
+	DoctorWarn DoctorStatus = "warn"
+	DoctorFail DoctorStatus = "fail"
+)
+
+// DoctorCheck is the result of one environment check.
+type DoctorCheck struct {
+	Name   string       `json:"name"`
+	Status DoctorStatus `json:"status"`
+	Detail string       `json:"detail"`
+	Hint   string       `json:"hint,omitempty"`
+}
+
+// runDoctor checks everything a generate run depends on. A failed check does not stop the
+// remaining ones, so a single run reports every problem.
+func runDoctor(ctx context.Context, cfg *Options) []DoctorCheck {
+	var checks []DoctorCheck
+	checks = append(checks, checkDocker(ctx, cfg.Container.Image)...)
+	checks = append(checks, checkGitBinary(ctx))
+	checks = append(checks, checkSSHAgent())
+	checks = append(checks, checkGitHubHostKey(ctx, cfg.SSH))
+	checks = append(checks, checkGitHubToken(ctx, cfg.logger(), cfg.GithubToken, cfg.GithubTokenSource, cfg.GithubAPIURL))
+	checks = append(checks, checkOutputDirs(cfg)...)
+	return checks
+}
+
+func checkDocker(ctx context.Context, generatorImage string) []DoctorCheck {
+	daemon := DoctorCheck{Name: "docker daemon"}
+
+	// The Docker client is used directly because testcontainers panics when it finds no daemon.
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		daemon.Status, daemon.Detail = DoctorFail, err.Error()
+		daemon.Hint = "install Docker and make sure DOCKER_HOST points to a running daemon"
+		return []DoctorCheck{daemon}
+	}
+	defer cli.Close()
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := cli.Ping(pingCtx); err != nil {
+		daemon.Status, daemon.Detail = DoctorFail, err.Error()
+		daemon.Hint = "start Docker (e.g. 'systemctl start docker' or Docker Desktop) and check that your user may access its socket"
+		return []DoctorCheck{daemon}
+	}
+	serverVersion, err := cli.ServerVersion(pingCtx)
+	if err != nil {
+		daemon.Status, daemon.Detail = DoctorPass, "reachable"
+	} else {
+		daemon.Status, daemon.Detail = DoctorPass, "reachable, version "+serverVersion.Version
+	}
+
+	image := DoctorCheck{Name: "generator image"}
+	if _, err := cli.ImageInspect(pingCtx, generatorImage); err != nil {
+		image.Status, image.Detail = DoctorWarn, generatorImage+" is not available locally"
+		image.Hint = "it is pulled on the first run; pull it now with 'docker pull " + generatorImage + "' when working offline"
+	} else {
+		image.Status, image.Detail = DoctorPass, generatorImage+" is available"
+	}
+
+	return []DoctorCheck{daemon, image}
+}
+
+func checkGitBinary(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "git binary"}
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		check.Status, check.Detail = DoctorFail, "git was not found in PATH"
+		check.Hint = "install git, it is required for private repos fetched over SSH"
+		return check
+	}
+
+	output, err := exec.CommandContext(ctx, gitPath, "--version").Output()
+	if err != nil {
+		check.Status, check.Detail = DoctorFail, fmt.Sprintf("failed to run %s --version: %v", gitPath, err)
+		return check
+	}
+
+	check.Status, check.Detail = DoctorPass, strings.TrimSpace(string(output))
+	return check
+}
+
+func checkSSHAgent() DoctorCheck {
+	check := DoctorCheck{Name: "ssh agent"}
+
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		check.Status, check.Detail = DoctorWarn, "SSH_AUTH_SOCK is not set"
+		check.Hint = "start an agent with 'eval $(ssh-agent)' and add your key with 'ssh-add', or pass --ssh-key, to fetch private repos over SSH"
+		return check
+	}
+
+	keys, err := sshAgentIdentities()
+	if err != nil {
+		check.Status, check.Detail = DoctorFail, err.Error()
+		check.Hint = "the agent is not running anymore, start a new one with 'eval $(ssh-agent)'"
+		return check
+	}
+	if keys == 0 {
+		check.Status, check.Detail = DoctorWarn, "the agent holds no identities"
+		check.Hint = "add your GitHub key with 'ssh-add ~/.ssh/id_ed25519', or pass --ssh-key"
+		return check
+	}
+
+	check.Status, check.Detail = DoctorPass, fmt.Sprintf("%d identities loaded", keys)
+	return check
+}
+
+// checkGitHubHostKey verifies the host key presented by the SSH host of the clone URLs, resolved
+// through ~/.ssh/config, against known_hosts, or the file given with --ssh-known-hosts. The SSH
+// handshake is aborted right after the host key check, no authentication is attempted.
+func checkGitHubHostKey(ctx context.Context, opts SSHOptions) DoctorCheck {
+	check := DoctorCheck{Name: opts.Host + " host key"}
+	endpoint := resolveSSHHost(opts.Host)
+
+	knownHostsPath := expandHome(opts.KnownHostsPath)
+	if knownHostsPath == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			check.Status, check.Detail = DoctorFail, err.Error()
+			return check
+		}
+		knownHostsPath = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		check.Status, check.Detail = DoctorWarn, fmt.Sprintf("failed to read '%s': %v", knownHostsPath, err)
+		check.Hint = endpoint.addHostKeyHint()
+		return check
+	}
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint.address())
+	if err != nil {
+		check.Status, check.Detail = DoctorWarn, fmt.Sprintf("failed to connect to %s: %v", endpoint.address(), err)
+		check.Hint = "SSH access may be blocked by a firewall; use --token instead"
+		return check
+	}
+	defer conn.Close()
+
+	checked := false
+	var hostKeyErr error
+	config := &ssh.ClientConfig{
+		User: "git",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			checked = true
+			hostKeyErr = callback(hostname, remote, key)
+			return errors.New("host key checked")
+		},
+		Timeout: 10 * time.Second,
+	}
+	if _, _, _, err := ssh.NewClientConn(conn, endpoint.address(), config); !checked {
+		check.Status, check.Detail = DoctorWarn, fmt.Sprintf("SSH handshake with %s failed: %v", endpoint.address(), err)
+		return check
+	}
+
+	var keyErr *knownhosts.KeyError
+	switch {
+	case hostKeyErr == nil:
+		check.Status, check.Detail = DoctorPass, "matches "+knownHostsPath
+	case errors.As(hostKeyErr, &keyErr) && len(keyErr.Want) == 0:
+		check.Status, check.Detail = DoctorFail, endpoint.knownHostsName()+" is not in "+knownHostsPath
+		check.Hint = endpoint.addHostKeyHint()
+	case errors.As(hostKeyErr, &keyErr):
+		check.Status, check.Detail = DoctorFail, "the host key of "+endpoint.knownHostsName()+" does not match "+knownHostsPath
+		check.Hint = fmt.Sprintf("the host rotated its key or the connection is intercepted; remove the old entry with 'ssh-keygen -R %s', then %s", endpoint.knownHostsName(), endpoint.addHostKeyHint())
+	default:
+		check.Status, check.Detail = DoctorFail, hostKeyErr.Error()
+	}
+	return check
+}
+
+// checkGitHubToken asks the GitHub API at apiURL who the token belongs to and which scopes it has.
+func checkGitHubToken(ctx context.Context, logger *slog.Logger, token, source, apiURL string) DoctorCheck {
+	check := DoctorCheck{Name: "github token"}
+	if token == "" {
+		check.Status, check.Detail = DoctorWarn, "no token found"
+		check.Hint = "set GITHUB_TOKEN or pass --token to check it; without one private repos are fetched over SSH and public repos anonymously"
+		return check
+	}
+
+	client, err := newGithubClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})), apiURL, logger, nil)
+	if err != nil {
+		check.Status, check.Detail = DoctorFail, err.Error()
+		check.Hint = "check --github-api-url"
+		return check
+	}
+	user, resp, err := client.Users.Get(ctx, "")
+	if err != nil {
+		check.Status, check.Detail = DoctorFail, fmt.Sprintf("GitHub rejected the token from %s: %v", source, err)
+		check.Hint = "create a new token at https://github.com/settings/tokens"
+		return check
+	}
+
+	scopesHeader := resp.Header.Get("X-OAuth-Scopes")
+	if scopesHeader == "" {
+		// Fine-grained tokens do not report scopes; their repository access is only known per repo.
+		check.Status, check.Detail = DoctorPass, fmt.Sprintf("valid fine-grained token for %s from %s", user.GetLogin(), source)
+		return check
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(scopesHeader, ",") {
+		scopes = append(scopes, strings.TrimSpace(scope))
+	}
+	if !slices.Contains(scopes, "repo") {
+		check.Status, check.Detail = DoctorFail, fmt.Sprintf("token for %s from %s has scopes [%s] but not 'repo'", user.GetLogin(), source, scopesHeader)
+		check.Hint = "private repos need the 'repo' scope; add it at https://github.com/settings/tokens"
+		return check
+	}
+
+	check.Status, check.Detail = DoctorPass, fmt.Sprintf("valid token for %s from %s with scopes [%s]", user.GetLogin(), source, scopesHeader)
+	return check
+}
+
+// checkOutputDirs checks that the output root, which holds the generation manifest, and the
+// fixed directory of every output layout can be written to.
+func checkOutputDirs(cfg *Options) []DoctorCheck {
+	dirs := append([]string{"."}, cfg.OutputDirs()...)
+	slices.Sort(dirs)
+
+	var checks []DoctorCheck
+	for _, dir := range slices.Compact(dirs) {
+		check := DoctorCheck{Name: "output directory " + dir}
+
+		// The directory is created on the first run, so its closest existing parent must be writable.
+		existing := filepath.Join(cfg.OutputRoot, dir)
+		for {
+			if _, err := os.Stat(existing); err == nil || existing == filepath.Dir(existing) {
+				break
+			}
+			existing = filepath.Dir(existing)
+		}
+
+		probe, err := os.CreateTemp(existing, ".git-proto-gen-doctor-")
+		if err != nil {
+			check.Status, check.Detail = DoctorFail, fmt.Sprintf("'%s' is not writable: %v", existing, err)
+			check.Hint = "fix the permissions of the directory or choose another --output"
+		} else {
+			probe.Close()
+			os.Remove(probe.Name())
+			check.Status, check.Detail = DoctorPass, fmt.Sprintf("'%s' is writable", existing)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
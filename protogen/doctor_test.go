@@ -0,0 +1,40 @@
+package protogen
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckGitHubToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" || r.Header.Get("Authorization") != "Bearer ghp_valid" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		w.Write([]byte(`{"login":"octocat"}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		token      string
+		wantStatus DoctorStatus
+		wantDetail string
+	}{
+		{token: "ghp_valid", wantStatus: DoctorPass, wantDetail: "valid token for octocat"},
+		{token: "ghp_revoked", wantStatus: DoctorFail, wantDetail: "GitHub rejected the token"},
+		{token: "", wantStatus: DoctorWarn, wantDetail: "no token found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			check := checkGitHubToken(context.Background(), slog.Default(), tt.token, "--token", server.URL)
+			if check.Status != tt.wantStatus || !strings.Contains(check.Detail, tt.wantDetail) {
+				t.Errorf("got %s %q, want %s containing %q", check.Status, check.Detail, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package protogen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind is the category of an error returned by the Generator.
+type ErrorKind string
+
+const (
+	ErrorKindInternal   ErrorKind = "internal"
+	ErrorKindConfig     ErrorKind = "config"
+	ErrorKindAuth       ErrorKind = "auth"
+	ErrorKindFetch      ErrorKind = "fetch"
+	ErrorKindContainer  ErrorKind = "container"
+	ErrorKindGeneration ErrorKind = "generation"
+	ErrorKindOutput     ErrorKind = "output"
+	ErrorKindCheck      ErrorKind = "check"
+	ErrorKindCanceled   ErrorKind = "canceled"
+)
+
+// Error is an error of a known category. The CLI derives its exit code from the kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithKind marks err as being of kind. Errors that already carry a kind keep it, so the most
+// specific classification made closest to the failure wins.
+func WithKind(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf returns the kind of err, or "" if it has none.
+func KindOf(err error) ErrorKind {
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return kindErr.Kind
+	}
+	return ""
+}
+
+// canceledError marks err as being of kind ErrorKindCanceled when ctx was canceled or timed
+// out, since err is then a consequence of that whatever kind it was given on the way.
+func canceledError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	reason := "canceled"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "timed out"
+	}
+	return &Error{Kind: ErrorKindCanceled, Err: fmt.Errorf("%s: %w", reason, err)}
+}
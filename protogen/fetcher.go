@@ -0,0 +1,86 @@
+package protogen
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// Fetcher fetches the .proto files of a source into dstDir, keeping their paths relative to the
+// source path and leaving out the files the include and exclude patterns of the source do not
+// select. The built-in fetchers record the ref or digest they fetched on src.
+type Fetcher interface {
+	Fetch(ctx context.Context, src *Source, dstDir string) error
+}
+
+// FetcherFunc adapts a function to a Fetcher.
+type FetcherFunc func(ctx context.Context, src *Source, dstDir string) error
+
+func (f FetcherFunc) Fetch(ctx context.Context, src *Source, dstDir string) error {
+	return f(ctx, src, dstDir)
+}
+
+// fetcher returns the fetcher for src: the one set for its type in Fetchers, or else the built-in
+// one, which for private sources depends on the selected authentication method.
+func (cfg *Options) fetcher(src *Source) Fetcher {
+	if f, ok := cfg.Fetchers[src.Type]; ok {
+		return f
+	}
+
+	switch src.Type {
+	case SourceTypePrivate:
+		if cfg.githubAuthMethod == githubAuthSSH {
+			return &gitFetcher{ssh: cfg.SSH, logger: cfg.logger()}
+		}
+		return &githubFetcher{client: cfg.githubClient, logger: cfg.logger()}
+	case SourceTypePublic:
+		return &githubFetcher{client: func(ctx context.Context, _, _ string) (*github.Client, error) {
+			return cfg.publicGithubClient(ctx)
+		}, logger: cfg.logger()}
+	case SourceTypeArchive:
+		return archiveFetcher{logger: cfg.logger()}
+	case SourceTypeOCI:
+		return ociFetcher{plainHTTP: cfg.OCIPlainHTTP, logger: cfg.logger()}
+	default:
+		return localFetcher{}
+	}
+}
+
+// localFetcher copies sources from the local filesystem.
+type localFetcher struct{}
+
+func (localFetcher) Fetch(_ context.Context, src *Source, dstDir string) error {
+	absLocalPath, err := filepath.Abs(src.Path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for local proto path '%s': %w", src.Path, err)
+	}
+
+	if err := copyLocalProtoToTemp(absLocalPath, dstDir, src.filter()); err != nil {
+		return fmt.Errorf("failed to copy local proto files from '%s' to temporary source workspace: %w", absLocalPath, err)
+	}
+	return nil
+}
+
+// archiveFetcher downloads or reads archives.
+type archiveFetcher struct {
+	logger *slog.Logger
+}
+
+func (f archiveFetcher) Fetch(ctx context.Context, src *Source, dstDir string) error {
+	return fetchArchive(ctx, f.logger, src, dstDir)
+}
+
+// ociFetcher pulls sources from OCI registries.
+type ociFetcher struct {
+	plainHTTP bool
+	logger    *slog.Logger
+}
+
+func (f ociFetcher) Fetch(ctx context.Context, src *Source, dstDir string) error {
+	var err error
+	src.digest, err = fetchOCIArtifact(ctx, f.logger, src, f.plainHTTP, dstDir)
+	return err
+}
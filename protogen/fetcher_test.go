@@ -0,0 +1,204 @@
+package protogen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Options without a Logger and the helpers under test report to the default logger.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// memFetcher is an in-memory Fetcher. It serves the files of each source path, keyed by their
+// slash-separated path relative to the source path, and reports every source at commit.
+type memFetcher struct {
+	sources map[string]map[string]string
+	commit  string
+}
+
+func (f *memFetcher) Fetch(_ context.Context, src *Source, dstDir string) error {
+	files, ok := f.sources[src.Path]
+	if !ok {
+		return fmt.Errorf("source '%s' not found", src.Path)
+	}
+
+	filter := src.filter()
+	selected := map[string]string{}
+	for rel, content := range files {
+		if filter.match(rel) {
+			selected[rel] = content
+		}
+	}
+	src.resolved = resolvedRef{Commit: f.commit}
+	return writeTree(dstDir, selected)
+}
+
+// writeTree writes files, keyed by their slash-separated path relative to dir.
+func writeTree(dir string, files map[string]string) error {
+	for rel, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	if err := writeTree(dir, files); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTree returns the files below dir keyed by their slash-separated path relative to dir.
+func readTree(t testing.TB, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func assertTree(t testing.TB, dir string, want map[string]string) {
+	t.Helper()
+	got := readTree(t, dir)
+	if !maps.Equal(got, want) {
+		t.Errorf("files in %s:\n got  %v\n want %v", dir, got, want)
+	}
+}
+
+func TestFetchWithFakeFetcher(t *testing.T) {
+	fake := &memFetcher{
+		commit: "3f2c1e9d0a7b4c5e6f708192a3b4c5d6e7f80912",
+		sources: map[string]map[string]string{
+			"github.com/acme/protos/proto@v1": {
+				"acme/orders.proto":        "syntax = \"proto3\";\nimport \"acme/common.proto\";\nimport \"google/protobuf/empty.proto\";\n",
+				"acme/common.proto":        "syntax = \"proto3\";\n",
+				"acme/testdata/fake.proto": "syntax = \"proto3\";\n",
+			},
+		},
+	}
+	var logs bytes.Buffer
+	g := New(Options{
+		Sources: []Source{{
+			Type:    SourceTypePrivate,
+			Path:    "github.com/acme/protos/proto@v1",
+			Exclude: []string{"**/testdata/**"},
+		}},
+		Fetchers: map[SourceType]Fetcher{SourceTypePrivate: fake},
+		Logger:   slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	dir := t.TempDir()
+	result, err := g.Fetch(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remote sources are mounted below their repository name, and their imports follow.
+	assertTree(t, dir, map[string]string{
+		"protos/acme/orders.proto": "syntax = \"proto3\";\nimport \"protos/acme/common.proto\";\nimport \"google/protobuf/empty.proto\";\n",
+		"protos/acme/common.proto": "syntax = \"proto3\";\n",
+	})
+
+	if len(result.Sources) != 1 {
+		t.Fatalf("got %d sources, want 1", len(result.Sources))
+	}
+	src := result.Sources[0]
+	if src.Name != "protos" || src.Commit != fake.commit {
+		t.Errorf("got source %q at commit %q, want %q at %q", src.Name, src.Commit, "protos", fake.commit)
+	}
+	if want := []string{"protos/acme/common.proto", "protos/acme/orders.proto"}; !slices.Equal(src.Files, want) {
+		t.Errorf("got files %v, want %v", src.Files, want)
+	}
+	if !strings.Contains(logs.String(), "mounted source into workspace") {
+		t.Errorf("got logs %q, want them to report the mounted source to Options.Logger", logs.String())
+	}
+}
+
+func TestFetchConflictingMounts(t *testing.T) {
+	fake := &memFetcher{sources: map[string]map[string]string{
+		"github.com/acme/a/proto": {"x.proto": "syntax = \"proto3\";\n"},
+		"github.com/acme/b/proto": {"x.proto": "syntax = \"proto3\";\n"},
+	}}
+	prefix := "shared"
+	g := New(Options{
+		Sources: []Source{
+			{Type: SourceTypePublic, Path: "github.com/acme/a/proto", Mount: Mount{Prefix: &prefix}},
+			{Type: SourceTypePublic, Path: "github.com/acme/b/proto", Mount: Mount{Prefix: &prefix}},
+		},
+		Fetchers: map[SourceType]Fetcher{SourceTypePublic: fake},
+	})
+
+	_, err := g.Fetch(context.Background(), t.TempDir())
+	if KindOf(err) != ErrorKindConfig {
+		t.Fatalf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindConfig)
+	}
+}
+
+func TestFetchSourceNotFound(t *testing.T) {
+	g := New(Options{
+		Sources:  []Source{{Type: SourceTypePublic, Path: "github.com/acme/missing/proto"}},
+		Fetchers: map[SourceType]Fetcher{SourceTypePublic: &memFetcher{}},
+	})
+
+	_, err := g.Fetch(context.Background(), t.TempDir())
+	if KindOf(err) != ErrorKindFetch {
+		t.Fatalf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindFetch)
+	}
+}
+
+func TestLocalFetcher(t *testing.T) {
+	srcDir := t.TempDir()
+	writeFiles(t, srcDir, map[string]string{
+		"acme/v1/orders.proto":   "v1",
+		"acme/v2/orders.proto":   "v2",
+		"acme/v1/README.md":      "not a proto",
+		"third_party/any.proto":  "vendored",
+		"acme/v1/internal.proto": "internal",
+	})
+	src := &Source{
+		Type:    SourceTypeLocal,
+		Path:    srcDir,
+		Include: []string{"**/v1/*.proto"},
+		Exclude: []string{"**/internal.proto"},
+	}
+
+	dstDir := t.TempDir()
+	if err := (localFetcher{}).Fetch(context.Background(), src, dstDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, dstDir, map[string]string{"acme/v1/orders.proto": "v1"})
+}
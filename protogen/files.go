@@ -0,0 +1,401 @@
+package protogen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var pluginOutRe = regexp.MustCompile(`(?m)^(\s*(?:-\s+)?)out:.*$`)
+
+// replaceWithRegex points the out directory of every plugin of a buf.gen template at the
+// __events__ placeholder.
+func replaceWithRegex(input []byte) []byte {
+	return pluginOutRe.ReplaceAll(input, []byte("${1}out: __events__"))
+}
+
+// bufConfigs are the contents of the buf configs written into the workspace.
+type bufConfigs struct {
+	yaml, genGo, genJs []byte
+}
+
+// loadBufConfigs returns the embedded buf configs, replaced by the files found in dir.
+func loadBufConfigs(logger *slog.Logger, dir string) bufConfigs {
+	configs := bufConfigs{yaml: bufYamlContent, genGo: bufGenGoYamlContent, genJs: bufGenJsYamlContent}
+	if dir == "" {
+		return configs
+	}
+
+	t, exist := getFileIfExists(filepath.Join(dir, bufYamlFileName))
+	if exist {
+		logger.Debug("Using local buf.yaml file")
+		configs.yaml = replaceWithRegex(t)
+	}
+
+	t, exist = getFileIfExists(filepath.Join(dir, bufGenGoYamlFileName))
+	if exist {
+		logger.Debug("Using local buf.gen.go.yaml file")
+		configs.genGo = replaceWithRegex(t)
+	}
+
+	t, exist = getFileIfExists(filepath.Join(dir, bufGenJsYamlFileName))
+	if exist {
+		logger.Debug("Using local buf.gen.js.yaml file")
+		configs.genJs = replaceWithRegex(t)
+	}
+
+	return configs
+}
+
+func getFileIfExists(fileName string) ([]byte, bool) {
+	_, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, false
+	}
+
+	return content, true
+}
+
+// rawGeneratedDirName is the plugin output directory written into the buf.gen templates. Files
+// generated there are moved into the configured output layout afterwards.
+const rawGeneratedDirName = "raw"
+
+func createBufConfigs(tempDir string, config *Options) error {
+	if err := os.WriteFile(filepath.Join(tempDir, bufYamlFileName), config.bufConfigs.yaml, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", bufYamlFileName, err)
+	}
+
+	goTemplate := config.bufConfigs.genGo
+	if config.GoModule != "" {
+		var err error
+		goTemplate, err = withGoPackagePrefix(goTemplate, config.GoModule)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, bufGenGoYamlFileName), bytes.ReplaceAll(goTemplate, []byte("__events__"), []byte(rawGeneratedDirName)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", bufGenGoYamlFileName, err)
+	}
+
+	jsTemplate := config.bufConfigs.genJs
+	if config.NpmPackage.Name != "" {
+		var err error
+		jsTemplate, err = withJsImportExtension(jsTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, bufGenJsYamlFileName), bytes.ReplaceAll(jsTemplate, []byte("__events__"), []byte(rawGeneratedDirName)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", bufGenJsYamlFileName, err)
+	}
+
+	return nil
+}
+
+// copyFile copies a single file from a source path to a destination path.
+func copyFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source file '%s': %w", src, err)
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file '%s': %w", dst, err)
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to copy content from '%s' to '%s': %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// copyLocalProtoToTemp recursively copies the .proto files selected by filter from srcDir to
+// dstDir. srcDir may also be a single .proto file, which is copied into dstDir.
+func copyLocalProtoToTemp(srcDir, dstDir string, filter fileFilter) error {
+	if info, err := os.Stat(srcDir); err == nil && !info.IsDir() {
+		if !strings.HasSuffix(info.Name(), ".proto") {
+			return fmt.Errorf("'%s' is not a .proto file or a directory containing .proto files", srcDir)
+		}
+		if !filter.match(info.Name()) {
+			return nil
+		}
+		if err := os.MkdirAll(dstDir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dstDir, err)
+		}
+		return copyFile(srcDir, filepath.Join(dstDir, info.Name()))
+	}
+
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", path, srcDir, err)
+		}
+
+		targetPath := filepath.Join(dstDir, relPath)
+		if info.IsDir() {
+			if relPath != "." && filter.excluded(filepath.ToSlash(relPath)) {
+				return filepath.SkipDir
+			}
+			if err := os.MkdirAll(targetPath, info.Mode()); err != nil {
+				return fmt.Errorf("failed to create directory '%s': %w", targetPath, err)
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(info.Name(), ".proto") && filter.match(filepath.ToSlash(relPath)) {
+			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(targetPath), err)
+			}
+			if err := copyFile(path, targetPath); err != nil {
+				return fmt.Errorf("failed to copy proto file '%s' to '%s': %w", path, targetPath, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+var protoImportRe = regexp.MustCompile(`(?m)^(\s*import\s+(?:public\s+|weak\s+)?")([^"]+)(")`)
+
+// mountSource copies the .proto files fetched for src into stageDir over to protoDir,
+// remapping their paths with the source mount rules. Imports that refer to files of the same
+// source are rewritten to the remapped paths. owners records which source each workspace file
+// came from, so that two sources mounting the same path are reported instead of overwritten.
+func mountSource(logger *slog.Logger, src *Source, stageDir, protoDir string, owners map[string]string) error {
+	files := map[string]bool{}
+	err := filepath.WalkDir(stageDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".proto") {
+			relPath, err := filepath.Rel(stageDir, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", path, stageDir, err)
+			}
+			files[filepath.ToSlash(relPath)] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list proto files of source '%s': %w", src.Name, err)
+	}
+	if len(files) == 0 {
+		logger.Warn("source contributes no .proto files, check its path and include and exclude patterns", "source", src.Name)
+	}
+
+	src.mounted = nil
+	for _, relPath := range slices.Sorted(maps.Keys(files)) {
+		mountedPath := src.Mount.mountPath(relPath)
+		if owner, exists := owners[mountedPath]; exists {
+			return WithKind(ErrorKindConfig, fmt.Errorf("source '%s' mounts '%s' at '%s', which is already provided by source '%s'", src.Name, relPath, mountedPath, owner))
+		}
+		owners[mountedPath] = src.Name
+		src.mounted = append(src.mounted, mountedPath)
+
+		content, err := os.ReadFile(filepath.Join(stageDir, filepath.FromSlash(relPath)))
+		if err != nil {
+			return fmt.Errorf("failed to read file '%s': %w", relPath, err)
+		}
+
+		content = protoImportRe.ReplaceAllFunc(content, func(match []byte) []byte {
+			parts := protoImportRe.FindSubmatch(match)
+			importPath := string(parts[2])
+			if !files[importPath] {
+				return match
+			}
+			return []byte(string(parts[1]) + src.Mount.mountPath(importPath) + string(parts[3]))
+		})
+
+		targetPath := filepath.Join(protoDir, filepath.FromSlash(mountedPath))
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(targetPath), err)
+		}
+		if err := os.WriteFile(targetPath, content, 0644); err != nil {
+			return fmt.Errorf("failed to write file '%s': %w", targetPath, err)
+		}
+	}
+
+	return nil
+}
+
+// copyGeneratedFiles recursively copies all files from srcDir to dstDir. Files already in dstDir
+// are replaced rather than overwritten, as they may be hard links to the current output.
+func copyGeneratedFiles(srcDir, dstDir string) error {
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", path, srcDir, err)
+		}
+
+		targetPath := filepath.Join(dstDir, relPath)
+		if info.IsDir() {
+			if err := os.MkdirAll(targetPath, info.Mode()); err != nil {
+				return fmt.Errorf("failed to create directory '%s': %w", targetPath, err)
+			}
+			return nil
+		}
+
+		if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace file '%s': %w", targetPath, err)
+		}
+		if err := copyFile(path, targetPath); err != nil {
+			return fmt.Errorf("failed to copy file '%s' to '%s': %w", path, targetPath, err)
+		}
+
+		return nil
+	})
+}
+
+// workspace holds the temporary directories of a run.
+type workspace struct {
+	dir          string            // buf configs and the proto module, mounted at /workspace
+	generatedDir string            // buf output, mounted at /workspace/temp_generated_output
+	outputRoot   string            // directory the output layouts are relative to
+	owners       map[string]string // proto file path in the module -> name of the source it came from
+	provenance   map[string]string // source name -> the remote path and ref it was fetched at
+	importOnly   []string          // proto file paths in the module that no code is generated for
+	logger       *slog.Logger
+}
+
+// remove removes the temporary directories of the workspace.
+func (ws *workspace) remove() {
+	for _, dir := range []string{ws.dir, ws.generatedDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			ws.logger.Warn("failed to remove temporary directory", "dir", dir, "error", err)
+		}
+	}
+}
+
+func (ws *workspace) protoDir() string {
+	return filepath.Join(ws.dir, "proto")
+}
+
+// excludePathArgs returns the buf generate arguments that keep the files of import-only sources
+// out of the generated code. They stay in the module, so other files can still import them.
+func (ws *workspace) excludePathArgs() []string {
+	var args []string
+	for _, p := range ws.importOnly {
+		args = append(args, "--exclude-path", path.Join("proto", p))
+	}
+	return args
+}
+
+// prepareTempFilesAndDirs creates the temporary workspace and fetches the sources into it. The
+// caller removes the workspace once done with it; on errors it is removed already.
+func prepareTempFilesAndDirs(ctx context.Context, config *Options) (_ *workspace, err error) {
+	absOutputPath := config.OutputRoot
+	if err := os.MkdirAll(absOutputPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory '%s': %w", absOutputPath, err)
+	}
+
+	tempWorkspace, err := os.MkdirTemp("", "bufSourceWorkspace")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary source workspace directory: %w", err)
+	}
+	ws := &workspace{dir: tempWorkspace, outputRoot: absOutputPath, owners: map[string]string{}, provenance: map[string]string{}, logger: config.logger()}
+	defer func() {
+		if err != nil {
+			ws.remove()
+		}
+	}()
+
+	hostProtoSubDir := ws.protoDir()
+	if err := os.MkdirAll(hostProtoSubDir, 0755); err != nil {
+		return ws, fmt.Errorf("failed to create 'proto' subdirectory '%s' in temporary source workspace: %w", hostProtoSubDir, err)
+	}
+
+	ws.generatedDir, err = os.MkdirTemp("", "bufGeneratedOutput")
+	if err != nil {
+		return ws, fmt.Errorf("failed to create temporary generated output directory: %w", err)
+	}
+
+	if err := createBufConfigs(tempWorkspace, config); err != nil {
+		return ws, fmt.Errorf("failed to create minimal buf config files: %w", err)
+	}
+
+	if err := fetchSources(ctx, config, hostProtoSubDir, ws.owners); err != nil {
+		return ws, fmt.Errorf("prepareTempFilesAndDirs: %w", err)
+	}
+	for _, src := range config.Sources {
+		if src.resolved.Commit != "" {
+			ws.provenance[src.Name] = fmt.Sprintf("%s@%s", strings.SplitN(src.Path, "@", 2)[0], src.resolved)
+		}
+		if src.Type == SourceTypeArchive {
+			ws.provenance[src.Name] = fmt.Sprintf("%s (sha256 %s)", src.Path, strings.ToLower(src.SHA256))
+		}
+		if src.digest != "" {
+			ws.provenance[src.Name] = fmt.Sprintf("%s (digest %s)", src.Path, src.digest)
+		}
+		if src.ImportOnly {
+			ws.importOnly = append(ws.importOnly, src.mounted...)
+		}
+	}
+
+	return ws, nil
+}
+
+// fetchSources fetches every source of config and mounts it into protoDir.
+func fetchSources(ctx context.Context, config *Options, protoDir string, owners map[string]string) error {
+	for i := range config.Sources {
+		src := &config.Sources[i]
+		if err := fetchAndMountSource(ctx, config, src, protoDir, owners); err != nil {
+			config.logger().Error("failed to fetch source", "source", src.Name, "type", src.Type, "path", src.Path, "error", err)
+			return fmt.Errorf("failed to fetch %s source '%s', err: %w", src.Type, src.Name, err)
+		}
+		config.logger().Info("mounted source into workspace", "source", src.Name, "type", src.Type, "prefix", *src.Mount.Prefix)
+	}
+	config.githubQuota.report(config.logger())
+
+	return nil
+}
+
+// fetchAndMountSource fetches the .proto files of src into a staging directory, keeping their
+// paths relative to the requested path, and then mounts them into protoDir.
+func fetchAndMountSource(ctx context.Context, config *Options, src *Source, protoDir string, owners map[string]string) error {
+	stageDir, err := os.MkdirTemp("", "sourceStage")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory for source '%s': %w", src.Name, err)
+	}
+	defer os.RemoveAll(stageDir)
+
+	if err := config.fetcher(src).Fetch(ctx, src, stageDir); err != nil {
+		return err
+	}
+
+	return mountSource(config.logger(), src, stageDir, protoDir, owners)
+}
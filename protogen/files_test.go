@@ -0,0 +1,142 @@
+package protogen
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReplaceWithRegex(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plugin output",
+			input: "plugins:\n  - remote: buf.build/protocolbuffers/go\n    out: gen/go\n    opt: paths=source_relative\n",
+			want:  "plugins:\n  - remote: buf.build/protocolbuffers/go\n    out: __events__\n    opt: paths=source_relative\n",
+		},
+		{
+			name:  "every plugin",
+			input: "- out: a\n- out: b/c\n",
+			want:  "- out: __events__\n- out: __events__\n",
+		},
+		{
+			name:  "last line without newline",
+			input: "out: events",
+			want:  "out: __events__",
+		},
+		{
+			name:  "other keys ending in out",
+			input: "plugins:\n  - local: protoc-gen-go\n    timeout: 10s\n    out: gen\n",
+			want:  "plugins:\n  - local: protoc-gen-go\n    timeout: 10s\n    out: __events__\n",
+		},
+		{
+			name:  "no output",
+			input: "version: v2\nmodules:\n  - path: proto\n",
+			want:  "version: v2\nmodules:\n  - path: proto\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(replaceWithRegex([]byte(tt.input))); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountSourceRewritesImports(t *testing.T) {
+	stageDir := t.TempDir()
+	writeFiles(t, stageDir, map[string]string{
+		"v1/orders.proto": `syntax = "proto3";
+import "v1/common.proto";
+  import public "v1/money.proto";
+import weak "v1/missing.proto";
+import "google/protobuf/timestamp.proto";
+// import "v1/common.proto";
+`,
+		"v1/common.proto": `syntax = "proto3";`,
+		"v1/money.proto":  `syntax = "proto3";`,
+	})
+
+	prefix := "acme"
+	src := &Source{
+		Name:  "orders",
+		Mount: Mount{Prefix: &prefix, Rename: []RenameRule{{From: "v1", To: "orders/v1"}}},
+	}
+	protoDir := t.TempDir()
+	owners := map[string]string{}
+	if err := mountSource(slog.Default(), src, stageDir, protoDir, owners); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTree(t, protoDir, map[string]string{
+		"acme/orders/v1/orders.proto": `syntax = "proto3";
+import "acme/orders/v1/common.proto";
+  import public "acme/orders/v1/money.proto";
+import weak "v1/missing.proto";
+import "google/protobuf/timestamp.proto";
+// import "v1/common.proto";
+`,
+		"acme/orders/v1/common.proto": `syntax = "proto3";`,
+		"acme/orders/v1/money.proto":  `syntax = "proto3";`,
+	})
+
+	want := []string{"acme/orders/v1/common.proto", "acme/orders/v1/money.proto", "acme/orders/v1/orders.proto"}
+	if !slices.Equal(src.mounted, want) {
+		t.Errorf("got mounted files %v, want %v", src.mounted, want)
+	}
+	for _, p := range want {
+		if owners[p] != "orders" {
+			t.Errorf("got owner %q for %s, want %q", owners[p], p, "orders")
+		}
+	}
+}
+
+func TestMountSourceStripPrefix(t *testing.T) {
+	stageDir := t.TempDir()
+	writeFiles(t, stageDir, map[string]string{
+		"proto/acme/a.proto": `import "proto/acme/b.proto";`,
+		"proto/acme/b.proto": ``,
+	})
+
+	prefix := ""
+	src := &Source{Name: "a", Mount: Mount{Prefix: &prefix, StripPrefix: "proto"}}
+	protoDir := t.TempDir()
+	if err := mountSource(slog.Default(), src, stageDir, protoDir, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTree(t, protoDir, map[string]string{
+		"acme/a.proto": `import "acme/b.proto";`,
+		"acme/b.proto": ``,
+	})
+}
+
+func TestCopyGeneratedFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	writeFiles(t, srcDir, map[string]string{
+		"events/acme/orders.pb.go":   "new orders",
+		"events/acme/v1/money.pb.go": "money",
+		"ts/orders_pb.ts":            "ts",
+	})
+	dstDir := filepath.Join(t.TempDir(), "out")
+	writeFiles(t, dstDir, map[string]string{
+		"events/acme/orders.pb.go": "old orders",
+		"events/keep.txt":          "not generated",
+	})
+
+	if err := copyGeneratedFiles(srcDir, dstDir); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTree(t, dstDir, map[string]string{
+		"events/acme/orders.pb.go":   "new orders",
+		"events/acme/v1/money.pb.go": "money",
+		"events/keep.txt":            "not generated",
+		"ts/orders_pb.ts":            "ts",
+	})
+}
@@ -0,0 +1,185 @@
+package protogen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const generationManifestFileName = ".git-proto-gen-manifest.json"
+
+// GenerationManifest records the output directories of the last generation run and every file
+// written to them, keyed by its slash-separated path relative to the output root, with the
+// SHA-256 of its content.
+type GenerationManifest struct {
+	Outputs []string          `json:"outputs"`
+	Files   map[string]string `json:"files"`
+}
+
+// loadGenerationManifest reads the manifest of the previous run from outputRoot. A missing
+// manifest yields an empty one, so a first run never removes anything.
+func loadGenerationManifest(outputRoot string) (*GenerationManifest, error) {
+	manifest := &GenerationManifest{Files: map[string]string{}}
+
+	content, err := os.ReadFile(filepath.Join(outputRoot, generationManifestFileName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generation manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse generation manifest '%s': %w", generationManifestFileName, err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]string{}
+	}
+
+	return manifest, nil
+}
+
+// buildGenerationManifest hashes every file under the generated directories. When several
+// directories contain the same path the last one wins, matching the order they are copied in.
+func buildGenerationManifest(outputs []string, generatedDirs ...string) (*GenerationManifest, error) {
+	manifest := &GenerationManifest{Outputs: outputs, Files: map[string]string{}}
+
+	for _, generatedDir := range generatedDirs {
+		if err := manifest.addFiles(generatedDir); err != nil {
+			return nil, fmt.Errorf("failed to build generation manifest: %w", err)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (m *GenerationManifest) addFiles(generatedDir string) error {
+	if _, err := os.Stat(generatedDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(generatedDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(generatedDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", path, generatedDir, err)
+		}
+
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		m.Files[filepath.ToSlash(relPath)] = sum
+
+		return nil
+	})
+}
+
+// within returns the entries below dir, keyed relative to dir.
+func (m *GenerationManifest) within(dir string) *GenerationManifest {
+	prefix := strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/") + "/"
+	manifest := &GenerationManifest{Files: map[string]string{}}
+	for relPath, sum := range m.Files {
+		if strings.HasPrefix(relPath, prefix) {
+			manifest.Files[strings.TrimPrefix(relPath, prefix)] = sum
+		}
+	}
+	return manifest
+}
+
+func (m *GenerationManifest) writeFile(manifestPath string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode generation manifest: %w", err)
+	}
+
+	if err := os.WriteFile(manifestPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write generation manifest '%s': %w", manifestPath, err)
+	}
+
+	return nil
+}
+
+// removeStaleGeneratedFiles deletes files listed in previous that are not part of current.
+// Files modified since they were generated are kept, and directories are only removed when
+// deleting a stale file left them empty.
+func removeStaleGeneratedFiles(logger *slog.Logger, outputRoot string, previous, current *GenerationManifest) error {
+	for _, relPath := range slices.Sorted(maps.Keys(previous.Files)) {
+		previousSum := previous.Files[relPath]
+		if _, ok := current.Files[relPath]; ok {
+			continue
+		}
+
+		cleanPath := filepath.Clean(filepath.FromSlash(relPath))
+		if filepath.IsAbs(cleanPath) || cleanPath == ".." || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+			logger.Warn("ignoring generation manifest entry outside the output directory", "path", relPath)
+			continue
+		}
+
+		path := filepath.Join(outputRoot, cleanPath)
+		sum, err := hashFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if sum != previousSum {
+			logger.Warn("keeping stale generated file because it was modified after generation", "path", relPath)
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale generated file '%s': %w", path, err)
+		}
+		logger.Info("removed stale generated file", "path", relPath)
+
+		removeEmptyParents(outputRoot, filepath.Dir(path))
+	}
+
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents while they are empty, stopping at root.
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file '%s': %w", path, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
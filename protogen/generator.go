@@ -0,0 +1,424 @@
+// Package protogen fetches .proto files from local directories, GitHub repositories, archives
+// and OCI registries into one buf workspace, and generates Go and TypeScript code for them with
+// buf in a bufbuild/buf container. It is the library behind the git-proto-gen command.
+package protogen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Generator runs the steps of git-proto-gen with a fixed set of options. The sources and
+// credentials are resolved by the first method called. A Generator must not be used concurrently.
+type Generator struct {
+	opts     Options
+	resolved bool
+}
+
+// New returns a Generator for opts. The sources are copied, so opts can be reused.
+func New(opts Options) *Generator {
+	opts.Sources = slices.Clone(opts.Sources)
+	opts.githubQuota = &rateLimitQuota{}
+	return &Generator{opts: opts}
+}
+
+// SourceResult describes a source as it was fetched.
+type SourceResult struct {
+	Name string     `json:"name"`
+	Type SourceType `json:"type"`
+	Path string     `json:"path"`
+	// Ref is the requested ref of a remote source, Version the tag selected for a semver range
+	// and Commit the commit the ref resolved to.
+	Ref     string `json:"ref,omitempty"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	// Digest is the manifest digest an OCI source was pulled at.
+	Digest string `json:"digest,omitempty"`
+	// Files are the workspace paths of the .proto files the source contributed.
+	Files []string `json:"files"`
+}
+
+// FetchResult is the result of Fetch.
+type FetchResult struct {
+	Dir     string         `json:"dir"`
+	Sources []SourceResult `json:"sources"`
+}
+
+// LanguageResult lists the files generated for one language.
+type LanguageResult struct {
+	Language string `json:"language"`
+	Layout   string `json:"layout"`
+	// Files are slash-separated paths relative to the output root.
+	Files []string `json:"files"`
+}
+
+// GenerateResult is the result of Generate.
+type GenerateResult struct {
+	OutputRoot string           `json:"output_root"`
+	Sources    []SourceResult   `json:"sources"`
+	Languages  []LanguageResult `json:"languages"`
+}
+
+// CheckResult is the result of a buf check that passed.
+type CheckResult struct {
+	Check   string         `json:"check"`
+	Sources []SourceResult `json:"sources"`
+}
+
+// PublishOptions configures Publish.
+type PublishOptions struct {
+	// Targets are OCI references with a tag to push the artifact to.
+	Targets []string
+	// WithGenerated also pushes the files recorded by the last Generate, as a separate layer.
+	WithGenerated bool
+}
+
+// PublishResult is the result of Publish.
+type PublishResult struct {
+	Targets []string       `json:"targets"`
+	Digest  string         `json:"digest"`
+	Sources []SourceResult `json:"sources"`
+}
+
+// CleanResult is the result of Clean.
+type CleanResult struct {
+	// Files are the generated files recorded by the last run. Files modified since they were
+	// generated are kept.
+	Files []string `json:"files"`
+}
+
+// resolve fills in the default options, and validates the sources and selects the credentials
+// for them. It only does so once.
+func (g *Generator) resolve(ctx context.Context) error {
+	if g.resolved {
+		return nil
+	}
+	if err := g.opts.withDefaults(); err != nil {
+		return WithKind(ErrorKindConfig, err)
+	}
+	if err := g.opts.resolveSources(ctx); err != nil {
+		return WithKind(ErrorKindConfig, err)
+	}
+	g.resolved = true
+	return nil
+}
+
+func (g *Generator) sourceResults() []SourceResult {
+	results := make([]SourceResult, 0, len(g.opts.Sources))
+	for _, src := range g.opts.Sources {
+		results = append(results, SourceResult{
+			Name:    src.Name,
+			Type:    src.Type,
+			Path:    src.Path,
+			Ref:     src.resolved.Ref,
+			Version: src.resolved.Version,
+			Commit:  src.resolved.Commit,
+			Digest:  src.digest,
+			Files:   slices.Clone(src.mounted),
+		})
+	}
+	return results
+}
+
+// Fetch fetches the sources into dir without generating code, laid out and with imports
+// rewritten exactly as they are for code generation.
+func (g *Generator) Fetch(ctx context.Context, dir string) (_ *FetchResult, err error) {
+	defer func() { err = canceledError(ctx, err) }()
+	if err := g.resolve(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, WithKind(ErrorKindOutput, fmt.Errorf("failed to create directory '%s': %w", dir, err))
+	}
+	if err := fetchSources(ctx, &g.opts, dir, map[string]string{}); err != nil {
+		return nil, WithKind(ErrorKindFetch, err)
+	}
+
+	g.opts.logger().Info("fetched proto sources", "dir", dir, "sources", len(g.opts.Sources))
+	return &FetchResult{Dir: dir, Sources: g.sourceResults()}, nil
+}
+
+// Generate fetches the sources and generates code for every language into its output layout.
+// The output is replaced as a whole: when any language fails, the previous output is kept.
+func (g *Generator) Generate(ctx context.Context) (_ *GenerateResult, err error) {
+	defer func() { err = canceledError(ctx, err) }()
+	if err := g.resolve(ctx); err != nil {
+		return nil, err
+	}
+	config := &g.opts
+	if err := config.validateGenerate(); err != nil {
+		return nil, WithKind(ErrorKindConfig, err)
+	}
+
+	ws, err := prepareTempFilesAndDirs(ctx, config)
+	if err != nil {
+		return nil, WithKind(ErrorKindFetch, fmt.Errorf("failed to prepare temporary files and directories: %w", err))
+	}
+	defer ws.remove()
+
+	previousManifest, err := loadGenerationManifest(ws.outputRoot)
+	if err != nil {
+		return nil, WithKind(ErrorKindOutput, err)
+	}
+
+	// Every language is generated into its own directory first, so a failing language never
+	// leaves the final output with only some of the languages updated.
+	var generatedDirs, outputs []string
+	for _, lang := range config.Languages {
+		layout := config.outputLayout(lang)
+		rawDir := filepath.Join(ws.generatedDir, lang, rawGeneratedDirName)
+		layoutDir := filepath.Join(ws.generatedDir, lang, "layout")
+		generatedDirs = append(generatedDirs, layoutDir)
+		outputs = append(outputs, layoutRoot(layout, lang))
+		containerOutputDir := "/workspace/temp_generated_output/" + lang
+
+		templateFile := bufGenGoYamlFileName
+		if lang == "js" {
+			templateFile = bufGenJsYamlFileName
+		}
+
+		bufCmd := append([]string{
+			"buf", "generate", ".",
+			"--template", filepath.Join("/workspace", templateFile),
+			"--output", containerOutputDir,
+		}, ws.excludePathArgs()...)
+
+		container, err := startGeneratorContainer(ctx, config, lang, ws)
+		if err != nil {
+			return nil, err
+		}
+		defer container.terminate()
+
+		if lang == "js" {
+			installDepsCmd := []string{"apk", "add", "--no-cache", "nodejs", "npm", "python3", "make", "g++"}
+			if err := execInContainer(ctx, container, "install dependencies", installDepsCmd); err != nil {
+				return nil, WithKind(ErrorKindContainer, err)
+			}
+
+			installNpmLocal := []string{"sh", "-c", "npm install --save-dev --verbose @bufbuild/protobuf @bufbuild/protoc-gen-es @bufbuild/buf 2>&1"}
+			if err := execInContainer(ctx, container, "install npm packages", installNpmLocal); err != nil {
+				return nil, WithKind(ErrorKindContainer, err)
+			}
+			generateCmd := "buf generate . --template /workspace/" + templateFile + " --output " + containerOutputDir
+			for _, arg := range ws.excludePathArgs() {
+				generateCmd += " " + shellQuote(arg)
+			}
+			bufCmd = []string{"sh", "-c", "export PATH=./node_modules/.bin:$PATH && " + generateCmd}
+		}
+
+		if err := execInContainer(ctx, container, "buf generate", bufCmd); err != nil {
+			return nil, WithKind(ErrorKindGeneration, err)
+		}
+
+		files, err := layoutGeneratedFiles(ws, lang, layout, rawDir, layoutDir)
+		if err != nil {
+			return nil, WithKind(ErrorKindGeneration, fmt.Errorf("failed to arrange generated %s files in output layout '%s': %w", lang, layout, err))
+		}
+
+		if lang == "go" && config.GoModule != "" {
+			moduleRoot := layoutRoot(layout, lang)
+			if err := writeGoMod(filepath.Join(layoutDir, moduleRoot), config.GoModule); err != nil {
+				return nil, WithKind(ErrorKindGeneration, err)
+			}
+			if config.VerifyGoModule {
+				if err := verifyGoModule(ctx, container, containerOutputDir+"/layout/"+moduleRoot); err != nil {
+					return nil, err
+				}
+			}
+			g.opts.logger().Info("wrote Go module for generated code", "module", config.GoModule, "path", moduleRoot)
+		}
+
+		if lang == "js" && config.NpmPackage.Name != "" {
+			packageRoot := layoutRoot(layout, lang)
+			if err := writeNpmPackage(layoutDir, packageRoot, config.NpmPackage, files); err != nil {
+				return nil, WithKind(ErrorKindGeneration, fmt.Errorf("failed to write npm package: %w", err))
+			}
+			if err := buildNpmPackage(ctx, container, containerOutputDir+"/layout/"+packageRoot, config.NpmPackage.Pack); err != nil {
+				return nil, err
+			}
+			g.opts.logger().Info("built npm package for generated code", "package", config.NpmPackage.Name, "version", config.NpmPackage.Version, "path", packageRoot)
+		}
+
+		g.opts.logger().Info("generated files into staging directory", "lang", lang, "layout", layout)
+	}
+
+	result := &GenerateResult{OutputRoot: ws.outputRoot, Sources: g.sourceResults()}
+	currentManifest := &GenerationManifest{Outputs: outputs, Files: map[string]string{}}
+	for i, lang := range config.Languages {
+		langManifest, err := buildGenerationManifest(nil, generatedDirs[i])
+		if err != nil {
+			return nil, WithKind(ErrorKindOutput, err)
+		}
+		maps.Copy(currentManifest.Files, langManifest.Files)
+		result.Languages = append(result.Languages, LanguageResult{
+			Language: lang,
+			Layout:   config.outputLayout(lang),
+			Files:    slices.Sorted(maps.Keys(langManifest.Files)),
+		})
+	}
+
+	tx, err := stageOutput(config.logger(), ws.outputRoot, generatedDirs, previousManifest, currentManifest)
+	if err != nil {
+		return nil, WithKind(ErrorKindOutput, fmt.Errorf("failed to stage generated files: %w", err))
+	}
+
+	if err := tx.commit(); err != nil {
+		return nil, WithKind(ErrorKindOutput, fmt.Errorf("failed to move generated files to final output path: %w", err))
+	}
+
+	g.opts.logger().Info("Generated files successfully copied to final output directory.")
+	return result, nil
+}
+
+// Lint lints the sources with buf lint.
+func (g *Generator) Lint(ctx context.Context) (*CheckResult, error) {
+	return g.runBufCheck(ctx, "lint", []string{"buf", "lint", "."})
+}
+
+// Breaking checks the sources for breaking changes with buf breaking. against is either a
+// directory, e.g. one written by Fetch, or any buf input such as
+// "https://github.com/acme/protos.git#branch=main,subdir=proto".
+func (g *Generator) Breaking(ctx context.Context, against string) (*CheckResult, error) {
+	if against == "" {
+		return nil, WithKind(ErrorKindConfig, errors.New("you must provide --against"))
+	}
+
+	var binds []string
+	input := against
+	if info, err := os.Stat(against); err == nil && info.IsDir() {
+		absAgainst, err := filepath.Abs(against)
+		if err != nil {
+			return nil, WithKind(ErrorKindConfig, fmt.Errorf("failed to get absolute path for '%s': %w", against, err))
+		}
+		binds = append(binds, absAgainst+":/against:ro")
+		input = "/against"
+	}
+
+	return g.runBufCheck(ctx, "breaking", []string{"buf", "breaking", ".", "--against", input}, binds...)
+}
+
+// runBufCheck fetches the sources into a workspace and runs a buf check command on it. A check
+// that does not pass returns an error of kind ErrorKindCheck.
+func (g *Generator) runBufCheck(ctx context.Context, name string, bufCmd []string, extraBinds ...string) (_ *CheckResult, err error) {
+	defer func() { err = canceledError(ctx, err) }()
+	if err := g.resolve(ctx); err != nil {
+		return nil, err
+	}
+
+	ws, err := prepareTempFilesAndDirs(ctx, &g.opts)
+	if err != nil {
+		return nil, WithKind(ErrorKindFetch, fmt.Errorf("failed to prepare temporary files and directories: %w", err))
+	}
+	defer ws.remove()
+
+	c, err := startGeneratorContainer(ctx, &g.opts, name, ws, extraBinds...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.terminate()
+
+	if err := execInContainer(ctx, c, "buf "+name, bufCmd); err != nil {
+		return nil, WithKind(ErrorKindCheck, err)
+	}
+
+	g.opts.logger().Info("buf check passed", "check", name)
+	return &CheckResult{Check: name, Sources: g.sourceResults()}, nil
+}
+
+// Publish fetches and merges the sources as Fetch does and pushes them to every target as an OCI
+// artifact, which other projects can use as an oci source.
+func (g *Generator) Publish(ctx context.Context, opts PublishOptions) (_ *PublishResult, err error) {
+	defer func() { err = canceledError(ctx, err) }()
+	if len(opts.Targets) == 0 {
+		return nil, WithKind(ErrorKindConfig, errors.New("you must provide --to"))
+	}
+	for _, target := range opts.Targets {
+		if err := validatePublishTarget(target); err != nil {
+			return nil, WithKind(ErrorKindConfig, err)
+		}
+	}
+	if err := g.resolve(ctx); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "publishProtos")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	owners := map[string]string{}
+	if err := fetchSources(ctx, &g.opts, dir, owners); err != nil {
+		return nil, WithKind(ErrorKindFetch, err)
+	}
+	layers := []ociLayer{{mediaType: ociProtoLayerMediaType, title: "proto.tar.gz", root: dir, files: slices.Sorted(maps.Keys(owners))}}
+
+	if opts.WithGenerated {
+		generated, err := loadGenerationManifest(g.opts.OutputRoot)
+		if err != nil {
+			return nil, WithKind(ErrorKindOutput, err)
+		}
+		if len(generated.Files) == 0 {
+			return nil, WithKind(ErrorKindConfig, fmt.Errorf("no generated files are recorded in '%s', run generate first", generationManifestFileName))
+		}
+		layers = append(layers, ociLayer{mediaType: ociGeneratedLayerMediaType, title: "generated.tar.gz", root: g.opts.OutputRoot, files: slices.Sorted(maps.Keys(generated.Files))})
+	}
+
+	digest, err := publishOCIArtifact(ctx, g.opts.logger(), layers, opts.Targets, g.opts.OCIPlainHTTP)
+	if err != nil {
+		return nil, WithKind(ErrorKindOutput, err)
+	}
+	return &PublishResult{Targets: opts.Targets, Digest: digest, Sources: g.sourceResults()}, nil
+}
+
+// Clean removes the generated files recorded in the generation manifest of the output root.
+// Files the tool did not generate, and generated files modified since, are kept.
+func (g *Generator) Clean(ctx context.Context) (*CleanResult, error) {
+	if err := g.opts.withDefaults(); err != nil {
+		return nil, WithKind(ErrorKindConfig, err)
+	}
+	outputRoot := g.opts.OutputRoot
+
+	previous, err := loadGenerationManifest(outputRoot)
+	if err != nil {
+		return nil, WithKind(ErrorKindOutput, err)
+	}
+	result := &CleanResult{Files: slices.Sorted(maps.Keys(previous.Files))}
+	if len(previous.Files) == 0 {
+		g.opts.logger().Info("nothing to clean")
+		return result, nil
+	}
+
+	tx, err := stageOutput(g.opts.logger(), outputRoot, nil, previous, &GenerationManifest{Files: map[string]string{}})
+	if err != nil {
+		return nil, WithKind(ErrorKindOutput, err)
+	}
+	if err := tx.commit(); err != nil {
+		return nil, WithKind(ErrorKindOutput, err)
+	}
+	if err := os.Remove(filepath.Join(outputRoot, generationManifestFileName)); err != nil {
+		return nil, WithKind(ErrorKindOutput, fmt.Errorf("failed to remove generation manifest: %w", err))
+	}
+
+	g.opts.logger().Info("removed generated files", "files", len(previous.Files))
+	return result, nil
+}
+
+// Doctor checks everything Generate depends on: Docker and the generator image, git, the SSH
+// agent and the host key of the SSH host, the GitHub token and its scopes, and that the output directories
+// are writable. A failed check does not stop the remaining ones.
+func (g *Generator) Doctor(ctx context.Context) ([]DoctorCheck, error) {
+	if err := g.opts.withDefaults(); err != nil {
+		return nil, WithKind(ErrorKindConfig, err)
+	}
+	if err := g.opts.resolveGithubToken(ctx); err != nil {
+		return nil, WithKind(ErrorKindAuth, err)
+	}
+	return runDoctor(ctx, &g.opts), nil
+}
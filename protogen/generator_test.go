@@ -0,0 +1,79 @@
+package protogen
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// blockingFetcher blocks until the context of the fetch is done.
+type blockingFetcher struct{}
+
+func (blockingFetcher) Fetch(ctx context.Context, _ *Source, _ string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGenerateCanceled(t *testing.T) {
+	tests := []struct {
+		name    string
+		context func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name: "canceled",
+			context: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+		{
+			name: "timed out",
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", tmpDir)
+
+			g := New(Options{
+				Sources:    []Source{{Type: SourceTypePublic, Path: "github.com/acme/protos/proto"}},
+				Languages:  []string{"go"},
+				OutputPath: "gen",
+				OutputRoot: t.TempDir(),
+				Fetchers:   map[SourceType]Fetcher{SourceTypePublic: blockingFetcher{}},
+			})
+			ctx, cancel := tt.context()
+			defer cancel()
+
+			_, err := g.Generate(ctx)
+			if KindOf(err) != ErrorKindCanceled || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v of kind %q, want %v of kind %q", err, KindOf(err), tt.wantErr, ErrorKindCanceled)
+			}
+
+			// The workspace, the generated output directory and the source stage are removed.
+			entries, err := os.ReadDir(tmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				t.Errorf("temporary %s was left behind", entry.Name())
+			}
+		})
+	}
+}
+
+func TestCanceledErrorKeepsOtherErrors(t *testing.T) {
+	err := WithKind(ErrorKindFetch, errors.New("not found"))
+	if got := canceledError(context.Background(), err); got != err {
+		t.Errorf("got %v, want the error unchanged", got)
+	}
+}
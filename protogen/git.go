@@ -0,0 +1,280 @@
+package protogen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+	"golang.org/x/oauth2"
+)
+
+func parseRepoPath(remotePath string) (owner, repo, path, branch string, err error) {
+	parts := strings.Split(remotePath, "@")
+	repoPath := parts[0]
+	if len(parts) > 1 {
+		branch = parts[1]
+	}
+
+	pathParts := strings.SplitN(repoPath, "/", 4)
+	if len(pathParts) < 4 || pathParts[0] != "github.com" {
+		err = fmt.Errorf("invalid repo path format: '%s'", remotePath)
+		return
+	}
+
+	owner = pathParts[1]
+	repo = pathParts[2]
+	path = pathParts[3]
+
+	return
+}
+
+// githubClient returns the GitHub API client for a repository, authenticated with the method
+// selected for private sources.
+func (cfg *Options) githubClient(ctx context.Context, owner, repo string) (*github.Client, error) {
+	switch cfg.githubAuthMethod {
+	case githubAuthApp:
+		return cfg.githubApp.client(ctx, owner, repo)
+	case githubAuthToken:
+		if cfg.GithubToken == "" {
+			return nil, WithKind(ErrorKindAuth, fmt.Errorf("gitHub token is required for private-repo access."))
+		}
+		return cfg.publicGithubClient(ctx)
+	default:
+		return cfg.publicGithubClient(ctx)
+	}
+}
+
+// publicGithubClient returns the GitHub API client for public repositories. It uses the token when
+// one is available, as authenticated requests have a much higher rate limit than anonymous ones.
+func (cfg *Options) publicGithubClient(ctx context.Context) (*github.Client, error) {
+	if cfg.GithubToken == "" {
+		return newGithubClient(nil, cfg.GithubAPIURL, cfg.logger(), cfg.githubQuota)
+	}
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: cfg.GithubToken},
+	)
+	return newGithubClient(oauth2.NewClient(ctx, ts), cfg.GithubAPIURL, cfg.logger(), cfg.githubQuota)
+}
+
+// githubFetcher downloads sources with the contents API of GitHub.
+type githubFetcher struct {
+	// client returns the API client for a repository.
+	client func(ctx context.Context, owner, repo string) (*github.Client, error)
+	logger *slog.Logger
+}
+
+// Fetch parses the GitHub path of src, resolves its ref and downloads the .proto files selected
+// by its filter into dstDir.
+func (f *githubFetcher) Fetch(ctx context.Context, src *Source, dstDir string) error {
+	f.logger.Info("downloading proto files using GitHub API", "remotePath", src.Path, "type", src.Type)
+	owner, repo, pathInRepo, ref, err := parseRepoPath(src.Path)
+	if err != nil {
+		return err
+	}
+
+	client, err := f.client(ctx, owner, repo)
+	if err != nil {
+		return err
+	}
+
+	if src.resolved, err = resolveGithubRef(ctx, f.logger, client, owner, repo, ref); err != nil {
+		return err
+	}
+	return fetchAndSaveGitHubContents(ctx, client, owner, repo, pathInRepo, src.resolved.Commit, src.filter(), "", dstDir)
+}
+
+// gitFetcher clones sources with git.
+type gitFetcher struct {
+	ssh SSHOptions
+	// remoteURL returns the URL to clone a repository from. It defaults to the SSH URL of the
+	// repository on ssh.Host.
+	remoteURL func(owner, repo string) string
+	logger    *slog.Logger
+}
+
+// Fetch checks out the requested path of the repository of src and copies the .proto files
+// selected by its filter into dstDir.
+func (f *gitFetcher) Fetch(ctx context.Context, src *Source, dstDir string) error {
+	f.logger.Info("downloading proto files using git", "remotePath", src.Path, "host", f.ssh.Host)
+	owner, repo, pathInRepo, ref, err := parseRepoPath(src.Path)
+	if err != nil {
+		return err
+	}
+
+	repoURL := fmt.Sprintf("git@%s:%s/%s.git", f.ssh.Host, owner, repo)
+	if f.remoteURL != nil {
+		repoURL = f.remoteURL(owner, repo)
+	}
+	tempRepoDir, err := os.MkdirTemp("", "tempRepo")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory for repository: %w", err)
+	}
+	defer os.RemoveAll(tempRepoDir)
+
+	if src.resolved, err = sparseCheckout(ctx, f.logger, f.ssh, repoURL, ref, pathInRepo, tempRepoDir); err != nil {
+		return err
+	}
+
+	sourcePath := filepath.Join(tempRepoDir, pathInRepo)
+	if err := copyLocalProtoToTemp(sourcePath, dstDir, src.filter()); err != nil {
+		return fmt.Errorf("failed to copy proto files from cloned repository: %w", err)
+	}
+
+	return nil
+}
+
+// sparseCheckout checks out only pathInRepo at ref of the repository at repoURL into dir. ref may
+// be a branch, a tag, a full or abbreviated commit SHA or a semver constraint, and defaults to the
+// remote HEAD. Only the single commit is fetched, without history, and only the blobs below
+// pathInRepo are downloaded.
+func sparseCheckout(ctx context.Context, logger *slog.Logger, opts SSHOptions, repoURL, ref, pathInRepo, dir string) (resolvedRef, error) {
+	resolved := resolvedRef{Ref: ref}
+
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", repoURL},
+		{"config", "core.sparseCheckout", "true"},
+	}
+	for _, args := range steps {
+		if _, err := runGit(ctx, opts, dir, args...); err != nil {
+			return resolved, err
+		}
+	}
+
+	resolved, target, err := resolveGitRef(ctx, opts, dir, ref)
+	if err != nil {
+		return resolved, err
+	}
+
+	// Non-cone patterns match both a directory and a single file.
+	sparsePattern := "/" + strings.Trim(filepath.ToSlash(pathInRepo), "/") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, ".git", "info", "sparse-checkout"), []byte(sparsePattern), 0644); err != nil {
+		return resolved, fmt.Errorf("failed to write sparse-checkout patterns: %w", err)
+	}
+
+	if _, err := runGit(ctx, opts, dir, "fetch", "--quiet", "--depth", "1", "--filter=blob:none", "origin", target); err != nil {
+		return resolved, err
+	}
+	if _, err := runGit(ctx, opts, dir, "checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return resolved, err
+	}
+
+	if resolved.Commit, err = runGit(ctx, opts, dir, "rev-parse", "HEAD"); err != nil {
+		return resolved, err
+	}
+	logger.Info("checked out repository", "url", repoURL, "ref", ref, "version", resolved.Version, "commit", resolved.Commit, "path", pathInRepo)
+
+	return resolved, nil
+}
+
+// runGit runs git in dir with the SSH options and returns its trimmed output. git never prompts
+// for credentials.
+func runGit(ctx context.Context, opts SSHOptions, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_SSH_COMMAND="+opts.gitSSHCommand(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", sshCloneError(opts.Host, stderr.String(), fmt.Errorf("git %s: %w", args[0], err))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// githubContentsError classifies an error of the contents API for githubPath: rate limits are
+// fetch errors, rejected credentials auth errors.
+func githubContentsError(resp *github.Response, err error, owner, repo, githubPath string) error {
+	if isGithubRateLimited(resp, err) {
+		return WithKind(ErrorKindFetch, fmt.Errorf("GitHub rate limit exceeded while reading repository '%s/%s', use a token for a higher limit: %w", owner, repo, err))
+	}
+	if resp != nil && resp.StatusCode == 401 {
+		return WithKind(ErrorKindAuth, fmt.Errorf("GitHub rejected the credentials for repository '%s/%s': %w", owner, repo, err))
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		return fmt.Errorf("path '%s' not found within repository '%s/%s'. Check path spelling or ensure it exists", githubPath, owner, repo)
+	}
+
+	return fmt.Errorf("failed to get contents for path '%s' in repository '%s/%s': %w", githubPath, owner, repo, err)
+}
+
+// fetchAndSaveGitHubContents fetches files (specifically .proto files) or directories
+// from a GitHub repository and saves them to the specified host destination directory.
+// rel is githubPath relative to the source path, which the patterns of filter are matched
+// against; files that are not selected and excluded directories are never downloaded.
+func fetchAndSaveGitHubContents(ctx context.Context, client *github.Client, owner, repo, githubPath, branch string, filter fileFilter, rel, hostDestDir string) error {
+	opts := &github.RepositoryContentGetOptions{}
+	if branch != "" {
+		opts.Ref = branch
+	}
+	fileContent, directoryContents, resp, err := client.Repositories.GetContents(ctx, owner, repo, githubPath, opts)
+	if err != nil {
+		return githubContentsError(resp, err, owner, repo, githubPath)
+	}
+
+	if directoryContents == nil {
+		if fileContent.GetType() == "file" && strings.HasSuffix(fileContent.GetName(), ".proto") {
+			if !filter.match(path.Join(rel, fileContent.GetName())) {
+				return nil
+			}
+			content, err := fileContent.GetContent()
+			if err != nil {
+				return fmt.Errorf("failed to decode content for file '%s': %w", fileContent.GetPath(), err)
+			}
+
+			filePath := filepath.Join(hostDestDir, fileContent.GetName())
+			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+				return fmt.Errorf("failed to write file '%s': %w", filePath, err)
+			}
+			return nil
+		}
+
+		return fmt.Errorf("private-repo path '%s' is not a .proto file or a directory containing .proto files", githubPath)
+	}
+
+	for _, item := range directoryContents {
+		itemPath := item.GetPath() // Full path of the item within the GitHub repo.
+		itemType := item.GetType() // Type of the item (e.g., "file", "dir").
+		itemName := item.GetName() // Name of the item (e.g., "my_service.proto", "sub_dir").
+		itemRel := path.Join(rel, itemName)
+
+		if itemType == "file" && strings.HasSuffix(itemName, ".proto") && filter.match(itemRel) {
+			singleFileContent, _, fileResp, err := client.Repositories.GetContents(ctx, owner, repo, itemPath, opts)
+			if err != nil {
+				return githubContentsError(fileResp, err, owner, repo, itemPath)
+			}
+
+			if singleFileContent.GetType() != "file" {
+				return fmt.Errorf("expected '%s' to be a file, but GitHub API returned type '%s'", itemPath, singleFileContent.GetType())
+			}
+
+			content, err := singleFileContent.GetContent()
+			if err != nil {
+				return fmt.Errorf("failed to decode content for file '%s': %w", itemPath, err)
+			}
+
+			filePath := filepath.Join(hostDestDir, itemName)
+			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+				return fmt.Errorf("failed to write file '%s': %w", filePath, err)
+			}
+		} else if itemType == "dir" && !filter.excluded(itemRel) {
+			subDirPath := filepath.Join(hostDestDir, itemName)
+			if err := os.MkdirAll(subDirPath, 0755); err != nil {
+				return fmt.Errorf("failed to create subdirectory '%s': %w", subDirPath, err)
+			}
+
+			if err := fetchAndSaveGitHubContents(ctx, client, owner, repo, itemPath, branch, filter, itemRel, subDirPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,399 @@
+package protogen
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRepoPath(t *testing.T) {
+	tests := []struct {
+		remotePath                string
+		owner, repo, path, branch string
+		wantErr                   bool
+	}{
+		{remotePath: "github.com/acme/protos/proto", owner: "acme", repo: "protos", path: "proto"},
+		{remotePath: "github.com/acme/protos/proto@main", owner: "acme", repo: "protos", path: "proto", branch: "main"},
+		{remotePath: "github.com/acme/protos/api/v1/orders.proto@^1.4", owner: "acme", repo: "protos", path: "api/v1/orders.proto", branch: "^1.4"},
+		{remotePath: "github.com/acme/protos/proto@3f2c1e9", owner: "acme", repo: "protos", path: "proto", branch: "3f2c1e9"},
+		{remotePath: "github.com/acme/protos", wantErr: true},
+		{remotePath: "github.com/acme/protos@main", wantErr: true},
+		{remotePath: "gitlab.com/acme/protos/proto", wantErr: true},
+		{remotePath: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remotePath, func(t *testing.T) {
+			owner, repo, p, branch, err := parseRepoPath(tt.remotePath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner != tt.owner || repo != tt.repo || p != tt.path || branch != tt.branch {
+				t.Errorf("got (%q, %q, %q, %q), want (%q, %q, %q, %q)", owner, repo, p, branch, tt.owner, tt.repo, tt.path, tt.branch)
+			}
+		})
+	}
+}
+
+// fakeGithub serves the parts of the GitHub REST API that githubFetcher uses from an in-memory
+// repository: commit SHA lookups, tags and the contents API.
+type fakeGithub struct {
+	owner, repo string
+	token       string                                 // required token, "" allows anonymous requests
+	refs        map[string]string                      // branch, tag or SHA -> commit
+	files       map[string]map[string]string           // commit -> path in the repository -> content
+	fileErrors  map[string]func(w http.ResponseWriter) // path of a file -> its failing response
+}
+
+func (f *fakeGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	prefix := "/repos/" + f.owner + "/" + f.repo + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case strings.HasPrefix(endpoint, "commits/"):
+		commit, ok := f.refs[strings.TrimPrefix(endpoint, "commits/")]
+		if !ok {
+			http.Error(w, `{"message":"No commit found"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte(commit))
+
+	case endpoint == "tags":
+		var tags []map[string]string
+		for ref := range f.refs {
+			if strings.HasPrefix(ref, "v") {
+				tags = append(tags, map[string]string{"name": ref})
+			}
+		}
+		json.NewEncoder(w).Encode(tags)
+
+	case strings.HasPrefix(endpoint, "contents/"):
+		f.serveContents(w, r, strings.TrimPrefix(endpoint, "contents/"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGithub) serveContents(w http.ResponseWriter, r *http.Request, p string) {
+	files, ok := f.files[r.URL.Query().Get("ref")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fail, ok := f.fileErrors[p]; ok {
+		fail(w)
+		return
+	}
+	if content, ok := files[p]; ok {
+		json.NewEncoder(w).Encode(map[string]string{
+			"type":     "file",
+			"name":     path.Base(p),
+			"path":     p,
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+		})
+		return
+	}
+
+	entries := map[string]string{}
+	for filePath := range files {
+		rel, ok := strings.CutPrefix(filePath, p+"/")
+		if !ok {
+			continue
+		}
+		if dir, _, nested := strings.Cut(rel, "/"); nested {
+			entries[dir] = "dir"
+		} else {
+			entries[rel] = "file"
+		}
+	}
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var listing []map[string]string
+	for name, entryType := range entries {
+		listing = append(listing, map[string]string{"type": entryType, "name": name, "path": p + "/" + name})
+	}
+	json.NewEncoder(w).Encode(listing)
+}
+
+func newFakeGithubOptions(t *testing.T, fake *fakeGithub) *Options {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &Options{GithubAPIURL: server.URL, GithubToken: fake.token}
+}
+
+func TestGithubFetcher(t *testing.T) {
+	const v1Commit, v12Commit, mainCommit = "1111111111111111111111111111111111111111", "1212121212121212121212121212121212121212", "9999999999999999999999999999999999999999"
+	const maintenanceCommit = "1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x1x"
+	fake := &fakeGithub{
+		owner: "acme",
+		repo:  "protos",
+		token: "s3cret",
+		refs:  map[string]string{"v1.0.0": v1Commit, "v1.2.0": v12Commit, "main": mainCommit, "HEAD": mainCommit, "1.x": maintenanceCommit},
+		files: map[string]map[string]string{
+			v1Commit:          {"proto/acme/orders.proto": "v1.0.0", "README.md": "readme"},
+			v12Commit:         {"proto/acme/orders.proto": "v1.2.0", "proto/acme/testdata/fake.proto": "fake"},
+			mainCommit:        {"proto/acme/orders.proto": "main", "proto/acme/v2/orders.proto": "main v2"},
+			maintenanceCommit: {"proto/acme/orders.proto": "1.x"},
+		},
+	}
+	cfg := newFakeGithubOptions(t, fake)
+
+	tests := []struct {
+		path    string
+		exclude []string
+		version string
+		commit  string
+		want    map[string]string
+	}{
+		{path: "github.com/acme/protos/proto", commit: mainCommit, want: map[string]string{"acme/orders.proto": "main", "acme/v2/orders.proto": "main v2"}},
+		{path: "github.com/acme/protos/proto@v1.0.0", commit: v1Commit, want: map[string]string{"acme/orders.proto": "v1.0.0"}},
+		{path: "github.com/acme/protos/proto@^1.1", exclude: []string{"**/testdata/**"}, version: "v1.2.0", commit: v12Commit, want: map[string]string{"acme/orders.proto": "v1.2.0"}},
+		{path: "github.com/acme/protos/proto/acme/orders.proto@main", commit: mainCommit, want: map[string]string{"orders.proto": "main"}},
+		// A branch named like a range is taken as the branch, other ranges select a tag.
+		{path: "github.com/acme/protos/proto@1.x", commit: maintenanceCommit, want: map[string]string{"acme/orders.proto": "1.x"}},
+		{path: "github.com/acme/protos/proto@1.*", version: "v1.2.0", commit: v12Commit, want: map[string]string{"acme/orders.proto": "v1.2.0", "acme/testdata/fake.proto": "fake"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			src := &Source{Type: SourceTypePublic, Path: tt.path, Exclude: tt.exclude}
+			dstDir := t.TempDir()
+			if err := cfg.fetcher(src).Fetch(context.Background(), src, dstDir); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dstDir, tt.want)
+			if src.resolved.Commit != tt.commit || src.resolved.Version != tt.version {
+				t.Errorf("got version %q at commit %q, want %q at %q", src.resolved.Version, src.resolved.Commit, tt.version, tt.commit)
+			}
+		})
+	}
+}
+
+func TestGithubFetcherErrors(t *testing.T) {
+	fake := &fakeGithub{
+		owner: "acme",
+		repo:  "protos",
+		token: "s3cret",
+		refs:  map[string]string{"main": "9999999999999999999999999999999999999999"},
+		files: map[string]map[string]string{"9999999999999999999999999999999999999999": {"proto/a.proto": "a"}},
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		kind  ErrorKind
+	}{
+		{name: "rejected token", token: "wrong", path: "github.com/acme/protos/proto@main", kind: ErrorKindAuth},
+		{name: "unknown ref", token: "s3cret", path: "github.com/acme/protos/proto@nope", kind: ErrorKindFetch},
+		{name: "unknown path", token: "s3cret", path: "github.com/acme/protos/missing@main", kind: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newFakeGithubOptions(t, fake)
+			cfg.GithubToken = tt.token
+			src := &Source{Type: SourceTypePublic, Path: tt.path}
+			err := cfg.fetcher(src).Fetch(context.Background(), src, t.TempDir())
+			if err == nil {
+				t.Fatal("got no error, want one")
+			}
+			if KindOf(err) != tt.kind {
+				t.Errorf("got error %v of kind %q, want kind %q", err, KindOf(err), tt.kind)
+			}
+		})
+	}
+}
+
+// gitRepo is a git working tree used to build the history of a test repository.
+type gitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func (r *gitRepo) git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// commit replaces the tree with files and commits it, returning the commit SHA.
+func (r *gitRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	r.git("rm", "-r", "--quiet", "--ignore-unmatch", ".")
+	writeFiles(r.t, r.dir, files)
+	r.git("add", "--all")
+	r.git("commit", "--quiet", "-m", "update")
+	return r.git("rev-parse", "HEAD")
+}
+
+// TestGitFetcherFromBareRepository clones sources from a bare repository over file://, the same
+// way private sources are cloned over SSH.
+func TestGitFetcherFromBareRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	bareDir := filepath.Join(root, "acme", "protos.git")
+	if err := os.MkdirAll(bareDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	(&gitRepo{t: t, dir: bareDir}).git("init", "--quiet", "--bare", "--initial-branch=main")
+
+	work := &gitRepo{t: t, dir: t.TempDir()}
+	work.git("init", "--quiet", "--initial-branch=main")
+	v1Commit := work.commit(map[string]string{"proto/acme/orders.proto": "v1.0.0", "docs/README.md": "docs"})
+	work.git("tag", "v1.0.0")
+	v12Commit := work.commit(map[string]string{"proto/acme/orders.proto": "v1.2.0", "proto/acme/testdata/fake.proto": "fake"})
+	work.git("tag", "v1.2.0")
+	mainCommit := work.commit(map[string]string{"proto/acme/orders.proto": "main", "proto/acme/v2/orders.proto": "main v2"})
+	// A maintenance branch named like a semver range.
+	work.git("checkout", "--quiet", "-b", "1.x", "v1.0.0")
+	maintenanceCommit := work.commit(map[string]string{"proto/acme/orders.proto": "1.x"})
+	work.git("checkout", "--quiet", "main")
+	work.git("push", "--quiet", "--tags", "file://"+bareDir, "main", "1.x")
+
+	fetcher := &gitFetcher{logger: slog.Default(), remoteURL: func(owner, repo string) string {
+		return "file://" + filepath.Join(root, owner, repo+".git")
+	}}
+
+	tests := []struct {
+		path    string
+		exclude []string
+		version string
+		commit  string
+		want    map[string]string
+	}{
+		{path: "github.com/acme/protos/proto", commit: mainCommit, want: map[string]string{"acme/orders.proto": "main", "acme/v2/orders.proto": "main v2"}},
+		{path: "github.com/acme/protos/proto@v1.0.0", commit: v1Commit, want: map[string]string{"acme/orders.proto": "v1.0.0"}},
+		{path: "github.com/acme/protos/proto@^1.1", exclude: []string{"**/testdata/**"}, version: "v1.2.0", commit: v12Commit, want: map[string]string{"acme/orders.proto": "v1.2.0"}},
+		{path: "github.com/acme/protos/proto@" + v1Commit[:7], commit: v1Commit, want: map[string]string{"acme/orders.proto": "v1.0.0"}},
+		{path: "github.com/acme/protos/proto/acme/orders.proto@main", commit: mainCommit, want: map[string]string{"orders.proto": "main"}},
+		{path: "github.com/acme/protos/proto@1.x", commit: maintenanceCommit, want: map[string]string{"acme/orders.proto": "1.x"}},
+		{path: "github.com/acme/protos/proto@1.*", version: "v1.2.0", commit: v12Commit, want: map[string]string{"acme/orders.proto": "v1.2.0", "acme/testdata/fake.proto": "fake"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			src := &Source{Type: SourceTypePrivate, Path: tt.path, Exclude: tt.exclude}
+			dstDir := t.TempDir()
+			if err := fetcher.Fetch(context.Background(), src, dstDir); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dstDir, tt.want)
+			if src.resolved.Commit != tt.commit || src.resolved.Version != tt.version {
+				t.Errorf("got version %q at commit %q, want %q at %q", src.resolved.Version, src.resolved.Commit, tt.version, tt.commit)
+			}
+		})
+	}
+
+	t.Run("generator", func(t *testing.T) {
+		g := New(Options{
+			Sources:  []Source{{Type: SourceTypePrivate, Path: "github.com/acme/protos/proto@^1"}},
+			Fetchers: map[SourceType]Fetcher{SourceTypePrivate: fetcher},
+		})
+		dir := t.TempDir()
+		result, err := g.Fetch(context.Background(), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, dir, map[string]string{"protos/acme/orders.proto": "v1.2.0", "protos/acme/testdata/fake.proto": "fake"})
+		if got := result.Sources[0]; got.Version != "v1.2.0" || got.Commit != v12Commit {
+			t.Errorf("got version %q at commit %q, want %q at %q", got.Version, got.Commit, "v1.2.0", v12Commit)
+		}
+	})
+
+	t.Run("unknown ref", func(t *testing.T) {
+		src := &Source{Type: SourceTypePrivate, Path: "github.com/acme/protos/proto@^3"}
+		err := fetcher.Fetch(context.Background(), src, t.TempDir())
+		if KindOf(err) != ErrorKindFetch {
+			t.Fatalf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindFetch)
+		}
+	})
+}
+
+func TestGithubFetcherFileErrors(t *testing.T) {
+	const commit = "9999999999999999999999999999999999999999"
+	tests := []struct {
+		name string
+		fail func(w http.ResponseWriter)
+		kind ErrorKind
+	}{
+		{
+			name: "rate limited",
+			fail: func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Limit", "60")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+				http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusForbidden)
+			},
+			kind: ErrorKindFetch,
+		},
+		{
+			name: "rejected credentials",
+			fail: func(w http.ResponseWriter) {
+				http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			},
+			kind: ErrorKindAuth,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The directory listing succeeds, only the download of one of its files fails.
+			fake := &fakeGithub{
+				owner:      "acme",
+				repo:       "protos",
+				refs:       map[string]string{"main": commit},
+				files:      map[string]map[string]string{commit: {"proto/a.proto": "a", "proto/b.proto": "b"}},
+				fileErrors: map[string]func(w http.ResponseWriter){"proto/b.proto": tt.fail},
+			}
+			cfg := newFakeGithubOptions(t, fake)
+			src := &Source{Type: SourceTypePublic, Path: "github.com/acme/protos/proto@main"}
+
+			err := cfg.fetcher(src).Fetch(context.Background(), src, t.TempDir())
+			if KindOf(err) != tt.kind {
+				t.Errorf("got error %v of kind %q, want kind %q", err, KindOf(err), tt.kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package protogen
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// DefaultGithubAPIURL is the base URL of the API of github.com.
+const DefaultGithubAPIURL = "https://api.github.com/"
+
+// Installation tokens are valid for an hour. They are replaced this long before they expire, so a
+// token never runs out in the middle of a fetch.
+const installationTokenRefreshMargin = 5 * time.Minute
+
+// GithubAppOptions configures authentication as a GitHub App installation.
+type GithubAppOptions struct {
+	AppID int64
+	// InstallationID is looked up per repository owner when not set.
+	InstallationID int64
+	PrivateKeyPath string
+}
+
+func (o GithubAppOptions) enabled() bool {
+	return o.AppID != 0 || o.PrivateKeyPath != "" || o.InstallationID != 0
+}
+
+// newGithubClient returns a GitHub API client using httpClient, or anonymous requests when it is
+// nil, against apiURL, e.g. a GitHub Enterprise Server API or a local stand-in for tests. Requests
+// are retried on rate limits and transient errors, and their rate limit is recorded in quota.
+func newGithubClient(httpClient *http.Client, apiURL string, logger *slog.Logger, quota *rateLimitQuota) (*github.Client, error) {
+	retrying := &http.Client{}
+	if httpClient != nil {
+		*retrying = *httpClient
+	}
+	retrying.Transport = newRetryTransport(retrying.Transport, logger, quota)
+
+	client := github.NewClient(retrying)
+	if apiURL == "" || apiURL == DefaultGithubAPIURL {
+		return client, nil
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL '%s': %w", apiURL, err)
+	}
+	client.BaseURL = baseURL
+	return client, nil
+}
+
+// githubApp mints installation tokens for a GitHub App. Each repository owner has its own
+// installation, whose token is cached and refreshed shortly before it expires.
+type githubApp struct {
+	opts   GithubAppOptions
+	apiURL string
+	logger *slog.Logger
+	quota  *rateLimitQuota
+	key    *rsa.PrivateKey
+	// jwtClient calls the API as the app itself, which is only allowed to manage installations.
+	jwtClient *github.Client
+
+	mu            sync.Mutex
+	installations map[string]*installationTransport
+}
+
+// newGithubApp loads the private key of the app configured in cfg.
+func newGithubApp(cfg *Options) (*githubApp, error) {
+	opts := cfg.GithubApp
+	if opts.AppID == 0 || opts.PrivateKeyPath == "" {
+		return nil, errors.New("GitHub App authentication requires both --github-app-id and --github-app-key")
+	}
+
+	key, err := loadRSAPrivateKey(opts.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &githubApp{
+		opts:          opts,
+		apiURL:        cfg.GithubAPIURL,
+		logger:        cfg.logger(),
+		quota:         cfg.githubQuota,
+		key:           key,
+		installations: map[string]*installationTransport{},
+	}
+	app.jwtClient, err = newGithubClient(&http.Client{Transport: &jwtTransport{app: app}}, app.apiURL, app.logger, app.quota)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key '%s': %w", path, err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key '%s' is not PEM encoded", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key '%s': %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key '%s' is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// jwt returns a JSON Web Token identifying the app, signed with RS256. Its issue time is set
+// slightly in the past to allow for clock drift, as GitHub recommends.
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprint(a.opts.AppID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtTransport authenticates requests with a freshly signed app JWT.
+type jwtTransport struct {
+	app *githubApp
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// client returns a client authenticated as the installation of the app for the repository. ctx
+// bounds the lookup of the installation; tokens are minted with the context of the request
+// that needs one.
+func (a *githubApp) client(ctx context.Context, owner, repo string) (*github.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	transport, ok := a.installations[owner]
+	if !ok {
+		installationID := a.opts.InstallationID
+		if installationID == 0 {
+			installation, resp, err := a.jwtClient.Apps.FindRepositoryInstallation(ctx, owner, repo)
+			if err != nil {
+				if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized) {
+					return nil, WithKind(ErrorKindAuth, fmt.Errorf("GitHub App %d is not installed for repository '%s/%s' or its key was rejected: %w", a.opts.AppID, owner, repo, err))
+				}
+				return nil, fmt.Errorf("failed to find GitHub App installation for '%s/%s': %w", owner, repo, err)
+			}
+			installationID = installation.GetID()
+		}
+
+		transport = &installationTransport{app: a, installationID: installationID}
+		a.installations[owner] = transport
+	}
+
+	return newGithubClient(&http.Client{Transport: transport}, a.apiURL, a.logger, a.quota)
+}
+
+// installationTransport authenticates requests with an installation token of the app. The token
+// is reused until shortly before it expires.
+type installationTransport struct {
+	app            *githubApp
+	installationID int64
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (t *installationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.currentToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// currentToken returns the cached installation token, minting a new one with ctx when there is
+// none or it is about to expire.
+func (t *installationTransport) currentToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Until(t.expiry) > installationTokenRefreshMargin {
+		return t.token, nil
+	}
+
+	token, resp, err := t.app.jwtClient.Apps.CreateInstallationToken(ctx, t.installationID, nil)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized) {
+			return "", WithKind(ErrorKindAuth, fmt.Errorf("GitHub rejected the installation token request for installation %d of app %d: %w", t.installationID, t.app.opts.AppID, err))
+		}
+		return "", fmt.Errorf("failed to create GitHub App installation token: %w", err)
+	}
+
+	t.app.logger.Info("created GitHub App installation token", "app_id", t.app.opts.AppID, "installation_id", t.installationID, "expires_at", token.GetExpiresAt().Time)
+	t.token, t.expiry = token.GetToken(), token.GetExpiresAt().Time
+	return t.token, nil
+}
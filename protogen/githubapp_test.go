@@ -0,0 +1,234 @@
+package protogen
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGithubApp serves the GitHub App endpoints used to mint installation tokens, checking the
+// app JWT of every request, and a repository endpoint that records the token it was called with.
+type fakeGithubApp struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PublicKey
+	// tokenLifetimes are the lifetimes of the tokens minted in turn; the last one is repeated.
+	tokenLifetimes []time.Duration
+
+	mu                  sync.Mutex
+	installationLookups int
+	tokensMinted        int
+	tokensSeen          []string
+	jwtErrors           []error
+}
+
+func (f *fakeGithubApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/protos/installation":
+		if err := f.verifyJWT(r); err != nil {
+			f.jwtErrors = append(f.jwtErrors, fmt.Errorf("installation lookup: %w", err))
+			http.Error(w, `{"message":"A JSON web token could not be decoded"}`, http.StatusUnauthorized)
+			return
+		}
+		f.installationLookups++
+		fmt.Fprintf(w, `{"id":%d}`, f.installationID)
+
+	case r.Method == http.MethodPost && r.URL.Path == fmt.Sprintf("/app/installations/%d/access_tokens", f.installationID):
+		if err := f.verifyJWT(r); err != nil {
+			f.jwtErrors = append(f.jwtErrors, fmt.Errorf("access token request: %w", err))
+			http.Error(w, `{"message":"A JSON web token could not be decoded"}`, http.StatusUnauthorized)
+			return
+		}
+		lifetime := f.tokenLifetimes[min(f.tokensMinted, len(f.tokenLifetimes)-1)]
+		f.tokensMinted++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":"ghs_%d","expires_at":%q}`, f.tokensMinted, time.Now().Add(lifetime).UTC().Format(time.RFC3339))
+
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/protos":
+		f.tokensSeen = append(f.tokensSeen, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		fmt.Fprint(w, `{"full_name":"acme/protos"}`)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verifyJWT checks that the request is authenticated with an RS256 JWT signed by the app key,
+// issued by the app and valid now for at most ten minutes, as GitHub requires.
+func (f *fakeGithubApp) verifyJWT(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return errors.New("missing bearer token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("JWT has %d parts, want 3", len(parts))
+	}
+
+	var header map[string]string
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		return fmt.Errorf("got JWT header %v, want alg RS256 and typ JWT", header)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("failed to decode JWT signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("invalid JWT signature: %w", err)
+	}
+
+	var claims struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if claims.Issuer != fmt.Sprint(f.appID) {
+		return fmt.Errorf("got JWT issuer %q, want %q", claims.Issuer, fmt.Sprint(f.appID))
+	}
+	if claims.IssuedAt > now || claims.ExpiresAt <= now {
+		return fmt.Errorf("JWT valid from %d to %d is not valid at %d", claims.IssuedAt, claims.ExpiresAt, now)
+	}
+	if claims.ExpiresAt-claims.IssuedAt > 600 {
+		return fmt.Errorf("JWT is valid for %ds, more than the allowed 10 minutes", claims.ExpiresAt-claims.IssuedAt)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("failed to decode JWT part: %w", err)
+	}
+	return json.Unmarshal(content, v)
+}
+
+// writeGithubAppKey writes a new RSA key to a PKCS#1 PEM file, as GitHub issues them.
+func writeGithubAppKey(t *testing.T) (string, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "app.private-key.pem")
+	content := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath, &key.PublicKey
+}
+
+func TestGithubAppInstallationTokens(t *testing.T) {
+	keyPath, publicKey := writeGithubAppKey(t)
+	fake := &fakeGithubApp{
+		appID:          1234,
+		installationID: 42,
+		key:            publicKey,
+		// The first token is already within the refresh margin, so it is replaced on its next use.
+		tokenLifetimes: []time.Duration{time.Minute, time.Hour},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	app, err := newGithubApp(&Options{GithubApp: GithubAppOptions{AppID: fake.appID, PrivateKeyPath: keyPath}, GithubAPIURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every round uses its own context, canceled once it is done, so tokens must be minted with
+	// the context of the request rather than the first one the app saw.
+	for range 3 {
+		ctx, cancel := context.WithCancel(context.Background())
+		client, err := app.client(ctx, "acme", "protos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := client.Repositories.Get(ctx, "acme", "protos"); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+	}
+
+	if want := []string{"ghs_1", "ghs_2", "ghs_2"}; strings.Join(fake.tokensSeen, ",") != strings.Join(want, ",") {
+		t.Errorf("got requests with tokens %v, want %v", fake.tokensSeen, want)
+	}
+	if len(fake.jwtErrors) > 0 {
+		t.Errorf("got invalid app JWTs: %v", fake.jwtErrors)
+	}
+	if fake.installationLookups != 1 {
+		t.Errorf("got %d installation lookups, want 1", fake.installationLookups)
+	}
+}
+
+func TestGithubAppRejectedKey(t *testing.T) {
+	keyPath, _ := writeGithubAppKey(t)
+	_, otherKey := writeGithubAppKey(t)
+	fake := &fakeGithubApp{appID: 1234, installationID: 42, key: otherKey}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	app, err := newGithubApp(&Options{GithubApp: GithubAppOptions{AppID: 1234, PrivateKeyPath: keyPath}, GithubAPIURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.client(context.Background(), "acme", "protos")
+	if KindOf(err) != ErrorKindAuth {
+		t.Errorf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindAuth)
+	}
+	if len(fake.jwtErrors) != 1 || !strings.Contains(fake.jwtErrors[0].Error(), "invalid JWT signature") {
+		t.Errorf("got JWT errors %v, want the signature of the installation lookup to be rejected", fake.jwtErrors)
+	}
+}
+
+func TestGithubAppKeepsTokenForPublicSources(t *testing.T) {
+	keyPath, _ := writeGithubAppKey(t)
+	t.Setenv("GITHUB_TOKEN", "s3cret")
+	cfg := &Options{
+		Sources: []Source{
+			{Type: SourceTypePrivate, Path: "github.com/acme/private/proto"},
+			{Type: SourceTypePublic, Path: "github.com/acme/public/proto"},
+		},
+		GithubApp: GithubAppOptions{AppID: 1234, PrivateKeyPath: keyPath},
+	}
+	if err := cfg.withDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.resolveSources(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.githubAuthMethod != githubAuthApp {
+		t.Errorf("got private sources authenticated with %q, want %q", cfg.githubAuthMethod, githubAuthApp)
+	}
+	// Public sources are fetched with the token rather than anonymously.
+	if cfg.GithubToken != "s3cret" || cfg.GithubTokenSource != "GITHUB_TOKEN" {
+		t.Errorf("got token %q from %q, want the one of GITHUB_TOKEN", cfg.GithubToken, cfg.GithubTokenSource)
+	}
+}
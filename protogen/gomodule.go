@@ -0,0 +1,129 @@
+package protogen
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Versions required by the go.mod written for generated Go code. They match the versions the
+// remote buf plugins of the embedded buf.gen.go.yaml generate code for.
+const (
+	goModuleGoVersion       = "1.23"
+	goModuleProtobufVersion = "v1.36.6"
+	goModuleGRPCVersion     = "v1.72.2"
+)
+
+// validateGoModule checks that the Go output layout can be turned into a module: every generated
+// file must be located below the module root at its proto path, so that go_package can be
+// derived from the module path.
+func validateGoModule(module, layout string) error {
+	if strings.ContainsAny(module, " \t\"'`") || strings.HasPrefix(module, "/") || strings.HasSuffix(module, "/") {
+		return fmt.Errorf("invalid Go module path '%s'", module)
+	}
+
+	if strings.Contains(layout, layoutSource) || strings.Contains(layout, layoutPackage) {
+		return fmt.Errorf("output layout '%s' cannot be used with --go-module: %s and %s placeholders are not supported for Go modules", layout, layoutSource, layoutPackage)
+	}
+
+	return nil
+}
+
+// withGoPackagePrefix rewrites a buf.gen template so that managed mode computes go_package
+// from module, e.g. "a/b/c.proto" gets "<module>/a/b".
+func withGoPackagePrefix(template []byte, module string) ([]byte, error) {
+	var config map[string]any
+	if err := yaml.Unmarshal(template, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", bufGenGoYamlFileName, err)
+	}
+	if config == nil {
+		config = map[string]any{}
+	}
+
+	managed, _ := config["managed"].(map[string]any)
+	if managed == nil {
+		managed = map[string]any{}
+	}
+	managed["enabled"] = true
+
+	if disable, ok := managed["disable"].([]any); ok {
+		var kept []any
+		for _, rule := range disable {
+			if r, ok := rule.(map[string]any); ok && r["file_option"] == "go_package" && len(r) == 1 {
+				continue
+			}
+			kept = append(kept, rule)
+		}
+		if len(kept) == 0 {
+			delete(managed, "disable")
+		} else {
+			managed["disable"] = kept
+		}
+	}
+
+	override, _ := managed["override"].([]any)
+	managed["override"] = append(override, map[string]any{
+		"file_option": "go_package_prefix",
+		"value":       module,
+	})
+	config["managed"] = managed
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", bufGenGoYamlFileName, err)
+	}
+	return content, nil
+}
+
+// writeGoMod writes the go.mod of the generated module into moduleDir, requiring grpc only
+// when gRPC service code was generated.
+func writeGoMod(moduleDir, module string) error {
+	requirements := []string{"google.golang.org/protobuf " + goModuleProtobufVersion}
+
+	hasGRPC := false
+	err := filepath.Walk(moduleDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(info.Name(), "_grpc.pb.go") {
+			hasGRPC = true
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to inspect generated Go files: %w", err)
+	}
+	if hasGRPC {
+		requirements = append([]string{"google.golang.org/grpc " + goModuleGRPCVersion}, requirements...)
+	}
+
+	content := fmt.Sprintf("module %s\n\ngo %s\n\nrequire (\n\t%s\n)\n", module, goModuleGoVersion, strings.Join(requirements, "\n\t"))
+
+	if err := os.MkdirAll(moduleDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", moduleDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(moduleDir, "go.mod"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write go.mod: %w", err)
+	}
+
+	return nil
+}
+
+// verifyGoModule installs Go in the generator container and builds the generated module,
+// which also records its checksums in go.sum.
+func verifyGoModule(ctx context.Context, c *generatorContainer, containerModuleDir string) error {
+	if err := execInContainer(ctx, c, "install go", []string{"apk", "add", "--no-cache", "go"}); err != nil {
+		return WithKind(ErrorKindContainer, err)
+	}
+
+	verifyCmd := fmt.Sprintf("cd %s && go mod tidy && go build ./... 2>&1", shellQuote(containerModuleDir))
+	if err := execInContainer(ctx, c, "build go module", []string{"sh", "-c", verifyCmd}); err != nil {
+		return WithKind(ErrorKindGeneration, fmt.Errorf("generated Go module does not build: %w", err))
+	}
+
+	return nil
+}
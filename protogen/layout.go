@@ -0,0 +1,202 @@
+package protogen
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	layoutLang    = "{lang}"
+	layoutSource  = "{source}"
+	layoutPackage = "{package}"
+)
+
+var (
+	layoutPlaceholderRe = regexp.MustCompile(`\{[^}]*\}`)
+	protoPackageRe      = regexp.MustCompile(`(?m)^\s*package\s+([A-Za-z0-9_.]+)\s*;`)
+)
+
+// validateLayout checks that an output layout only uses the supported placeholders and starts
+// with a fixed directory, which is the directory swapped into place on every run.
+func validateLayout(layout string) error {
+	for _, placeholder := range layoutPlaceholderRe.FindAllString(layout, -1) {
+		if placeholder != layoutLang && placeholder != layoutSource && placeholder != layoutPackage {
+			return fmt.Errorf("invalid placeholder '%s' in output layout '%s'. Allowed values: %s, %s, %s", placeholder, layout, layoutLang, layoutSource, layoutPackage)
+		}
+	}
+
+	if filepath.IsAbs(layout) || slices.Contains(strings.Split(path.Clean(filepath.ToSlash(layout)), "/"), "..") {
+		return fmt.Errorf("output layout '%s' must be relative to the working directory", layout)
+	}
+
+	if root := layoutRoot(layout, "go"); root == "." || root == "" {
+		return fmt.Errorf("output layout '%s' must start with a fixed directory, e.g: 'gen/{lang}'", layout)
+	}
+
+	return nil
+}
+
+// layoutRoot returns the fixed leading directories of layout for lang, i.e. everything before the
+// first segment that depends on the source or package of a file.
+func layoutRoot(layout, lang string) string {
+	segments := strings.Split(path.Clean(filepath.ToSlash(strings.ReplaceAll(layout, layoutLang, lang))), "/")
+	for i, segment := range segments {
+		if strings.Contains(segment, layoutSource) || strings.Contains(segment, layoutPackage) {
+			return path.Join(segments[:i]...)
+		}
+	}
+	return path.Join(segments...)
+}
+
+// generatedFile is a file placed in an output layout.
+type generatedFile struct {
+	path         string // slash-separated path relative to the layout directory
+	protoPath    string // proto file it was generated from, "" if unknown
+	protoPackage string
+}
+
+// layoutGeneratedFiles moves the files buf generated for lang from rawDir into layoutDir,
+// placing each file in the directory rendered from layout for the proto file it was generated from.
+// With a {package} placeholder only the file name is kept, otherwise the path buf generated is kept
+// below the rendered directory.
+func layoutGeneratedFiles(ws *workspace, lang, layout, rawDir, layoutDir string) ([]generatedFile, error) {
+	if _, err := os.Stat(rawDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var files []generatedFile
+	packages := map[string]string{}
+	// generatedFrom maps each target path to the generated file moved there, to catch files that
+	// {package} puts in the same place.
+	generatedFrom := map[string]string{}
+	err := filepath.Walk(rawDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(rawDir, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", filePath, rawDir, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		protoPath := ws.protoFileFor(relPath)
+		if protoPath == "" {
+			ws.logger.Warn("could not find the proto file a generated file belongs to", "lang", lang, "path", relPath)
+		}
+
+		protoPackage, ok := packages[protoPath]
+		if !ok && protoPath != "" {
+			protoPackage, err = ws.protoPackage(protoPath)
+			if err != nil {
+				return err
+			}
+			packages[protoPath] = protoPackage
+		}
+
+		dir := strings.NewReplacer(
+			layoutLang, lang,
+			layoutSource, ws.owners[protoPath],
+			layoutPackage, strings.ReplaceAll(protoPackage, ".", "/"),
+		).Replace(layout)
+
+		targetPath := path.Join(dir, relPath)
+		if strings.Contains(layout, layoutPackage) {
+			targetPath = path.Join(dir, path.Base(relPath))
+		}
+		if other, ok := generatedFrom[targetPath]; ok {
+			return WithKind(ErrorKindConfig, fmt.Errorf("generated %s files '%s' and '%s' both map to '%s' with output layout '%s'; rename one of their proto files or use a layout without {package}", lang, other, relPath, targetPath, layout))
+		}
+		generatedFrom[targetPath] = relPath
+
+		files = append(files, generatedFile{path: targetPath, protoPath: protoPath, protoPackage: protoPackage})
+
+		targetPath = filepath.Join(layoutDir, filepath.FromSlash(targetPath))
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %w", filepath.Dir(targetPath), err)
+		}
+		if err := os.Rename(filePath, targetPath); err != nil {
+			return fmt.Errorf("failed to move generated file '%s' to '%s': %w", filePath, targetPath, err)
+		}
+		if provenance := ws.provenance[ws.owners[protoPath]]; provenance != "" {
+			if err := addProvenanceHeader(targetPath, provenance); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// addProvenanceHeader records the remote source and resolved ref a generated file was produced
+// from in a comment on its first line. Files of other languages than Go and JS/TS are left as is.
+func addProvenanceHeader(filePath, provenance string) error {
+	switch filepath.Ext(filePath) {
+	case ".go", ".ts", ".js", ".mjs", ".cjs":
+	default:
+		return nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read generated file '%s': %w", filePath, err)
+	}
+	header := fmt.Sprintf("// Generated from %s.\n", provenance)
+	if err := os.WriteFile(filePath, append([]byte(header), content...), 0644); err != nil {
+		return fmt.Errorf("failed to write generated file '%s': %w", filePath, err)
+	}
+	return nil
+}
+
+// protoFileFor returns the workspace proto file a generated file was produced from, matching
+// the longest proto file name in the same directory that the generated file name starts with,
+// e.g. "greeting_grpc.pb.go" and "greeting_pb.ts" both belong to "greeting.proto".
+func (ws *workspace) protoFileFor(generatedPath string) string {
+	dir, name := path.Split(generatedPath)
+
+	best := ""
+	for protoPath := range ws.owners {
+		protoDir, protoName := path.Split(protoPath)
+		if protoDir != dir {
+			continue
+		}
+
+		stem := strings.TrimSuffix(protoName, ".proto")
+		rest, ok := strings.CutPrefix(name, stem)
+		if !ok || (rest != "" && rest[0] != '.' && rest[0] != '_') {
+			continue
+		}
+		if len(protoPath) > len(best) {
+			best = protoPath
+		}
+	}
+
+	return best
+}
+
+// protoPackage returns the package declared by a workspace proto file, or "" if it has none.
+func (ws *workspace) protoPackage(protoPath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(ws.protoDir(), filepath.FromSlash(protoPath)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read proto file '%s': %w", protoPath, err)
+	}
+
+	match := protoPackageRe.FindSubmatch(content)
+	if match == nil {
+		return "", nil
+	}
+	return string(match[1]), nil
+}
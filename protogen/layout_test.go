@@ -0,0 +1,192 @@
+package protogen
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestValidateLayout(t *testing.T) {
+	tests := []struct {
+		layout  string
+		wantErr bool
+	}{
+		{layout: "gen/{lang}"},
+		{layout: "gen/{lang}/{source}"},
+		{layout: "gen/{lang}/{package}"},
+		{layout: "gen/{source}-{lang}"},
+		{layout: "./api/generated/{lang}"},
+		{layout: "gen/{language}", wantErr: true},
+		{layout: "../gen/{lang}", wantErr: true},
+		{layout: "gen/../../{lang}", wantErr: true},
+		{layout: "/tmp/gen/{lang}", wantErr: true},
+		// The first directory depends on the file, so there is no directory to swap into place.
+		{layout: "{source}/{lang}", wantErr: true},
+		{layout: "{package}", wantErr: true},
+		{layout: ".", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			err := validateLayout(tt.layout)
+			if tt.wantErr && err == nil {
+				t.Fatalf("got no error, want one")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLayoutRoot(t *testing.T) {
+	tests := []struct {
+		layout, lang, want string
+	}{
+		{layout: "gen/{lang}", lang: "go", want: "gen/go"},
+		{layout: "gen/{lang}/{source}", lang: "ts", want: "gen/ts"},
+		{layout: "gen/{lang}/{package}/v1", lang: "go", want: "gen/go"},
+		{layout: "gen/{source}-{lang}", lang: "go", want: "gen"},
+		{layout: "./api//{lang}/", lang: "go", want: "api/go"},
+		{layout: "{source}/{lang}", lang: "go", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			if got := layoutRoot(tt.layout, tt.lang); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtoFileFor(t *testing.T) {
+	ws := &workspace{owners: map[string]string{
+		"acme/greeting.proto":         "acme",
+		"acme/greeting_service.proto": "acme",
+		"acme/v1/greeting.proto":      "acme",
+		"billing/invoice.proto":       "billing",
+	}}
+
+	tests := []struct {
+		generatedPath, want string
+	}{
+		{generatedPath: "acme/greeting.pb.go", want: "acme/greeting.proto"},
+		{generatedPath: "acme/greeting_grpc.pb.go", want: "acme/greeting.proto"},
+		{generatedPath: "acme/greeting_pb.ts", want: "acme/greeting.proto"},
+		// greeting.proto is a prefix too, the longest stem wins.
+		{generatedPath: "acme/greeting_service.pb.go", want: "acme/greeting_service.proto"},
+		{generatedPath: "acme/greeting_service_grpc.pb.go", want: "acme/greeting_service.proto"},
+		{generatedPath: "acme/v1/greeting.pb.go", want: "acme/v1/greeting.proto"},
+		// The generated file must be in the directory of its proto file.
+		{generatedPath: "greeting.pb.go", want: ""},
+		// The stem must end at a separator, not in the middle of a word.
+		{generatedPath: "acme/greetings.pb.go", want: ""},
+		{generatedPath: "billing/invoice_pb2.py", want: "billing/invoice.proto"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.generatedPath, func(t *testing.T) {
+			if got := ws.protoFileFor(tt.generatedPath); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutGeneratedFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		want   map[string]string
+	}{
+		{
+			name:   "source",
+			layout: "gen/{lang}/{source}",
+			want: map[string]string{
+				"gen/go/acme/acme/orders/v1/orders.pb.go":      "orders",
+				"gen/go/acme/acme/orders/v1/orders_grpc.pb.go": "orders grpc",
+				"gen/go/billing/billing/invoice.pb.go":         "invoice",
+			},
+		},
+		{
+			// The package replaces the directories buf generated, so only file names are kept.
+			name:   "package",
+			layout: "gen/{lang}/{package}",
+			want: map[string]string{
+				"gen/go/acme/orders/v1/orders.pb.go":      "orders",
+				"gen/go/acme/orders/v1/orders_grpc.pb.go": "orders grpc",
+				"gen/go/invoice.pb.go":                    "invoice",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := &workspace{
+				dir:    t.TempDir(),
+				logger: slog.Default(),
+				owners: map[string]string{
+					"acme/orders/v1/orders.proto": "acme",
+					"billing/invoice.proto":       "billing",
+				},
+			}
+			writeFiles(t, ws.protoDir(), map[string]string{
+				"acme/orders/v1/orders.proto": "syntax = \"proto3\";\n\npackage acme.orders.v1;\n",
+				// A file without a package lands directly in the rendered directory.
+				"billing/invoice.proto": "syntax = \"proto3\";\n",
+			})
+			rawDir := t.TempDir()
+			writeFiles(t, rawDir, map[string]string{
+				"acme/orders/v1/orders.pb.go":      "orders",
+				"acme/orders/v1/orders_grpc.pb.go": "orders grpc",
+				"billing/invoice.pb.go":            "invoice",
+			})
+			layoutDir := t.TempDir()
+
+			files, err := layoutGeneratedFiles(ws, "go", tt.layout, rawDir, layoutDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertTree(t, layoutDir, tt.want)
+			if len(files) != len(tt.want) {
+				t.Errorf("got %d generated files, want %d", len(files), len(tt.want))
+			}
+			for _, file := range files {
+				if _, ok := tt.want[file.path]; !ok {
+					t.Errorf("got generated file %q, not in the layout", file.path)
+				}
+			}
+			assertTree(t, rawDir, map[string]string{})
+		})
+	}
+}
+
+func TestLayoutGeneratedFilesCollision(t *testing.T) {
+	ws := &workspace{
+		dir: t.TempDir(),
+		owners: map[string]string{
+			"acme/orders/v1/types.proto":  "acme",
+			"acme/billing/v1/types.proto": "acme",
+		},
+		logger: slog.Default(),
+	}
+	// Both files declare the same package, so {package} puts their code in the same directory.
+	writeFiles(t, ws.protoDir(), map[string]string{
+		"acme/orders/v1/types.proto":  "syntax = \"proto3\";\n\npackage acme.v1;\n",
+		"acme/billing/v1/types.proto": "syntax = \"proto3\";\n\npackage acme.v1;\n",
+	})
+	rawDir := t.TempDir()
+	writeFiles(t, rawDir, map[string]string{
+		"acme/orders/v1/types.pb.go":  "orders",
+		"acme/billing/v1/types.pb.go": "billing",
+	})
+
+	_, err := layoutGeneratedFiles(ws, "go", "gen/{lang}/{package}", rawDir, t.TempDir())
+	if KindOf(err) != ErrorKindConfig {
+		t.Fatalf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindConfig)
+	}
+	for _, source := range []string{"acme/orders/v1/types.pb.go", "acme/billing/v1/types.pb.go"} {
+		if !strings.Contains(err.Error(), source) {
+			t.Errorf("got error %q, want it to name %q", err, source)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package protogen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultManifestFileName is the file name of the project manifest.
+const DefaultManifestFileName = "git-proto-gen.yaml"
+
+// Manifest is the optional project file describing the proto sources of a project and where
+// their generated code is written. Command line flags take precedence over the manifest.
+type Manifest struct {
+	Sources    []Source          `yaml:"sources,omitempty"`
+	Languages  []string          `yaml:"languages,omitempty,flow"`
+	Output     string            `yaml:"output,omitempty"`
+	Outputs    map[string]string `yaml:"outputs,omitempty"`
+	GoModule   string            `yaml:"go_module,omitempty"`
+	NpmPackage *NpmPackage       `yaml:"npm_package,omitempty"`
+	BufConfigs string            `yaml:"buf_configs,omitempty"`
+	Container  *ContainerOptions `yaml:"container,omitempty"`
+	// Tokens maps a GitHub host to its token. Values are expanded from the environment, so
+	// "${GITHUB_ACME_TOKEN}" keeps the token itself out of the manifest.
+	Tokens map[string]string `yaml:"tokens,omitempty"`
+}
+
+// LoadManifest reads the project manifest. A missing manifest is only an error when
+// it was requested explicitly.
+func LoadManifest(manifestPath string, explicit bool) (*Manifest, error) {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &Manifest{}, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest '%s': %w", manifestPath, err)
+	}
+
+	var manifest Manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse manifest '%s': %w", manifestPath, err)
+	}
+
+	return &manifest, nil
+}
@@ -0,0 +1,260 @@
+package protogen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	npmProtobufPeerRange = "^2.0.0"
+	npmTypeScriptVersion = "^5.8.0"
+)
+
+var (
+	npmPackageNameRe    = regexp.MustCompile(`^(@[a-z0-9][a-z0-9._~-]*/)?[a-z0-9][a-z0-9._~-]*$`)
+	npmPackageVersionRe = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	nonIdentifierRe     = regexp.MustCompile(`[^A-Za-z0-9_$]`)
+)
+
+// NpmPackage configures the npm package built from the generated TypeScript code.
+type NpmPackage struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version,omitempty"`
+	Pack    bool   `yaml:"pack,omitempty"`
+}
+
+func validateNpmPackage(pkg NpmPackage) error {
+	if !npmPackageNameRe.MatchString(pkg.Name) {
+		return fmt.Errorf("invalid npm package name '%s'", pkg.Name)
+	}
+	if !npmPackageVersionRe.MatchString(pkg.Version) {
+		return fmt.Errorf("invalid npm package version '%s', expected a semantic version like 1.2.3", pkg.Version)
+	}
+	return nil
+}
+
+// withJsImportExtension rewrites a buf.gen template so that protoc-gen-es adds the ".js"
+// extension to relative imports, which ES modules require.
+func withJsImportExtension(template []byte) ([]byte, error) {
+	var config map[string]any
+	if err := yaml.Unmarshal(template, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", bufGenJsYamlFileName, err)
+	}
+
+	plugins, _ := config["plugins"].([]any)
+	for _, p := range plugins {
+		plugin, ok := p.(map[string]any)
+		if !ok || !strings.Contains(fmt.Sprint(plugin["local"], plugin["remote"]), "protoc-gen-es") {
+			continue
+		}
+
+		var opts []any
+		switch opt := plugin["opt"].(type) {
+		case string:
+			opts = []any{opt}
+		case []any:
+			opts = opt
+		}
+		opts = slices.DeleteFunc(opts, func(o any) bool {
+			return strings.HasPrefix(fmt.Sprint(o), "import_extension=")
+		})
+		plugin["opt"] = append(opts, "import_extension=js")
+	}
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", bufGenJsYamlFileName, err)
+	}
+	return content, nil
+}
+
+// npmPackageJSON is the package.json of the generated npm package. It is a struct rather than a
+// map because the order of export conditions matters: "types" must come before "default".
+type npmPackageJSON struct {
+	Name             string                `json:"name"`
+	Version          string                `json:"version"`
+	Type             string                `json:"type"`
+	Main             string                `json:"main"`
+	Module           string                `json:"module"`
+	Types            string                `json:"types"`
+	Exports          map[string]npmExports `json:"exports"`
+	Files            []string              `json:"files"`
+	SideEffects      bool                  `json:"sideEffects"`
+	PeerDependencies map[string]string     `json:"peerDependencies"`
+	DevDependencies  map[string]string     `json:"devDependencies"`
+}
+
+type npmExports struct {
+	Import  npmExportConditions `json:"import"`
+	Require npmExportConditions `json:"require"`
+}
+
+type npmExportConditions struct {
+	Types   string `json:"types"`
+	Default string `json:"default"`
+}
+
+// writeNpmPackage turns packageDir into an npm package: it writes package.json, the TypeScript
+// configs for the ESM and CJS builds, one index.ts barrel per proto package and a root index.ts
+// exporting every package barrel as a namespace. files are the generated files of the layout,
+// relative to the layout directory packageDir belongs to, which is layoutDir/packageRoot.
+func writeNpmPackage(layoutDir, packageRoot string, pkg NpmPackage, files []generatedFile) error {
+	packageDir := filepath.Join(layoutDir, packageRoot)
+
+	packages := map[string][]string{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.path, ".ts") || strings.HasSuffix(file.path, ".d.ts") {
+			continue
+		}
+		relPath := strings.TrimPrefix(file.path, packageRoot+"/")
+		packages[file.protoPackage] = append(packages[file.protoPackage], relPath)
+	}
+	if len(packages) == 0 {
+		return fmt.Errorf("no TypeScript files were generated into '%s'", packageRoot)
+	}
+
+	var rootIndex []string
+	for _, protoPackage := range slices.Sorted(maps.Keys(packages)) {
+		sources := packages[protoPackage]
+		slices.Sort(sources)
+
+		if protoPackage == "" {
+			for _, source := range sources {
+				rootIndex = append(rootIndex, fmt.Sprintf("export * from %q;", importSpecifier(".", source)))
+			}
+			continue
+		}
+
+		barrelDir := strings.ReplaceAll(protoPackage, ".", "/")
+		var barrel []string
+		for _, source := range sources {
+			barrel = append(barrel, fmt.Sprintf("export * from %q;", importSpecifier(barrelDir, source)))
+		}
+		if err := writeLines(filepath.Join(packageDir, filepath.FromSlash(barrelDir), "index.ts"), barrel); err != nil {
+			return err
+		}
+
+		namespace := nonIdentifierRe.ReplaceAllString(strings.ReplaceAll(protoPackage, ".", "_"), "_")
+		rootIndex = append(rootIndex, fmt.Sprintf("export * as %s from %q;", namespace, importSpecifier(".", barrelDir+"/index.ts")))
+	}
+	if err := writeLines(filepath.Join(packageDir, "index.ts"), rootIndex); err != nil {
+		return err
+	}
+
+	packageJSON := npmPackageJSON{
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		Type:    "module",
+		Main:    "./dist/cjs/index.js",
+		Module:  "./dist/esm/index.js",
+		Types:   "./dist/esm/index.d.ts",
+		Exports: map[string]npmExports{
+			".": {
+				Import:  npmExportConditions{Types: "./dist/esm/index.d.ts", Default: "./dist/esm/index.js"},
+				Require: npmExportConditions{Types: "./dist/cjs/index.d.ts", Default: "./dist/cjs/index.js"},
+			},
+		},
+		Files:            []string{"dist"},
+		SideEffects:      false,
+		PeerDependencies: map[string]string{"@bufbuild/protobuf": npmProtobufPeerRange},
+		DevDependencies:  map[string]string{"@bufbuild/protobuf": npmProtobufPeerRange, "typescript": npmTypeScriptVersion},
+	}
+	if err := writeJSON(filepath.Join(packageDir, "package.json"), packageJSON); err != nil {
+		return err
+	}
+
+	compilerOptions := map[string]any{
+		"target":       "ES2020",
+		"declaration":  true,
+		"strict":       true,
+		"skipLibCheck": true,
+	}
+	configs := map[string]map[string]any{
+		"tsconfig.esm.json": {"module": "ES2020", "moduleResolution": "bundler", "outDir": "dist/esm"},
+		"tsconfig.cjs.json": {"module": "CommonJS", "moduleResolution": "node10", "outDir": "dist/cjs"},
+	}
+	for name, options := range configs {
+		for k, v := range compilerOptions {
+			options[k] = v
+		}
+		tsconfig := map[string]any{
+			"compilerOptions": options,
+			"include":         []string{"**/*.ts"},
+			"exclude":         []string{"dist", "node_modules"},
+		}
+		if err := writeJSON(filepath.Join(packageDir, name), tsconfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildNpmPackage compiles the package in containerPackageDir to ESM and CJS with declaration
+// files, and runs npm pack when requested. It expects node and the @bufbuild/protobuf runtime
+// to be installed in /workspace by the JS generation step.
+func buildNpmPackage(ctx context.Context, c *generatorContainer, containerPackageDir string, pack bool) error {
+	installCmd := "npm install --save-dev typescript@" + npmTypeScriptVersion + " 2>&1"
+	if err := execInContainer(ctx, c, "install typescript", []string{"sh", "-c", installCmd}); err != nil {
+		return WithKind(ErrorKindContainer, err)
+	}
+
+	buildCmd := fmt.Sprintf(
+		`cd %s && /workspace/node_modules/.bin/tsc -p tsconfig.esm.json && /workspace/node_modules/.bin/tsc -p tsconfig.cjs.json && echo '{"type":"commonjs"}' > dist/cjs/package.json`,
+		shellQuote(containerPackageDir),
+	)
+	if err := execInContainer(ctx, c, "compile npm package", []string{"sh", "-c", buildCmd + " 2>&1"}); err != nil {
+		return WithKind(ErrorKindGeneration, err)
+	}
+
+	if pack {
+		packCmd := fmt.Sprintf("cd %s && npm pack 2>&1", shellQuote(containerPackageDir))
+		if err := execInContainer(ctx, c, "pack npm package", []string{"sh", "-c", packCmd}); err != nil {
+			return WithKind(ErrorKindGeneration, err)
+		}
+	}
+
+	return nil
+}
+
+// importSpecifier returns the ES module specifier importing source from fromDir, both relative
+// to the package root.
+func importSpecifier(fromDir, source string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(fromDir), filepath.FromSlash(source))
+	if err != nil {
+		rel = source
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, ".") {
+		rel = "./" + rel
+	}
+	return strings.TrimSuffix(rel, path.Ext(rel)) + ".js"
+}
+
+func writeLines(filePath string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", filepath.Dir(filePath), err)
+	}
+	if err := os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", filePath, err)
+	}
+	return nil
+}
+
+func writeJSON(filePath string, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode '%s': %w", filePath, err)
+	}
+	return writeLines(filePath, []string{string(content)})
+}
@@ -0,0 +1,257 @@
+package protogen
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const (
+	// ociArtifactType identifies the artifacts pushed by the publish command.
+	ociArtifactType = "application/vnd.git-proto-gen.protos.v1"
+	// ociProtoLayerMediaType is a gzip-compressed tar of the merged .proto files.
+	ociProtoLayerMediaType = "application/vnd.git-proto-gen.proto.v1.tar+gzip"
+	// ociGeneratedLayerMediaType is a gzip-compressed tar of the generated code, which sources
+	// pulling the artifact skip.
+	ociGeneratedLayerMediaType = "application/vnd.git-proto-gen.generated.v1.tar+gzip"
+)
+
+// newOCIRepository returns a client for the repository of ref, authenticated with the credentials
+// docker and oras log in with: ~/.docker/config.json and its credential helpers.
+func newOCIRepository(ref string, plainHTTP bool) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI reference '%s': %w", ref, err)
+	}
+	repo.PlainHTTP = plainHTTP
+
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry credentials from the Docker config: %w", err)
+	}
+	repo.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}
+	return repo, nil
+}
+
+// ociName returns the default name of an OCI source: the last element of its repository, e.g.
+// "events" for "registry.acme.com/schemas/events:1.4.0".
+func ociName(ref string) (string, error) {
+	parsed, err := registry.ParseReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid OCI reference '%s': %w", ref, err)
+	}
+	return path.Base(parsed.Repository), nil
+}
+
+// fetchOCIArtifact pulls the artifact of src, a tag or digest reference, and extracts its .proto
+// files below the subdir of src that its filter selects into dstDir. It returns the digest of the
+// manifest. Layers that are .proto files, as `oras push` uploads them, are written as they are;
+// tar layers, such as those of the publish command or of `oras push` with a directory, are
+// extracted. Other layers are skipped.
+func fetchOCIArtifact(ctx context.Context, logger *slog.Logger, src *Source, plainHTTP bool, dstDir string) (string, error) {
+	repo, err := newOCIRepository(src.Path, plainHTTP)
+	if err != nil {
+		return "", err
+	}
+
+	reference := repo.Reference.ReferenceOrDefault()
+	desc, rc, err := repo.FetchReference(ctx, reference)
+	if err != nil {
+		return "", ociError(src.Path, err)
+	}
+	manifestContent, err := content.ReadAll(rc, desc)
+	rc.Close()
+	if err != nil {
+		return "", WithKind(ErrorKindFetch, fmt.Errorf("failed to read manifest of '%s': %w", src.Path, err))
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return "", WithKind(ErrorKindFetch, fmt.Errorf("'%s' is a '%s', not an OCI image manifest", src.Path, desc.MediaType))
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestContent, &manifest); err != nil {
+		return "", WithKind(ErrorKindFetch, fmt.Errorf("failed to parse manifest of '%s': %w", src.Path, err))
+	}
+
+	subdir := strings.Trim(src.Subdir, "/")
+	ex := &archiveExtractor{subdir: subdir, filter: src.filter(), dstDir: dstDir}
+	for _, layer := range manifest.Layers {
+		title := layer.Annotations[ocispec.AnnotationTitle]
+		isFile := strings.HasSuffix(title, ".proto")
+		isArchive := layer.MediaType == ociProtoLayerMediaType || layer.MediaType == ocispec.MediaTypeImageLayer ||
+			layer.MediaType == ocispec.MediaTypeImageLayerGzip
+		if !isFile && !isArchive {
+			logger.Debug("skipping OCI layer", "source", src.Name, "mediaType", layer.MediaType, "title", title)
+			continue
+		}
+
+		blob, err := content.FetchAll(ctx, repo, layer)
+		if err != nil {
+			return "", ociError(src.Path, err)
+		}
+		if isFile {
+			if err := ex.extract(title, bytes.NewReader(blob)); err != nil {
+				return "", err
+			}
+			continue
+		}
+		extracted, err := extractArchive(bytes.NewReader(blob), int64(len(blob)), subdir, src.filter(), dstDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to extract layer '%s' of '%s': %w", layer.Digest, src.Path, err)
+		}
+		ex.count += extracted
+	}
+
+	logger.Info("pulled OCI artifact", "source", src.Name, "reference", src.Path, "digest", desc.Digest, "files", ex.count)
+	return desc.Digest.String(), nil
+}
+
+// ociError classifies an error of a registry request.
+func ociError(ref string, err error) error {
+	if errors.Is(err, errdef.ErrNotFound) {
+		return WithKind(ErrorKindFetch, fmt.Errorf("'%s' not found in the registry, check the repository and tag or digest", ref))
+	}
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) {
+		switch errResp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return WithKind(ErrorKindAuth, fmt.Errorf("registry rejected the credentials for '%s', log in with 'docker login' or 'oras login': %w", ref, err))
+		case http.StatusNotFound:
+			return WithKind(ErrorKindFetch, fmt.Errorf("'%s' not found in the registry: %w", ref, err))
+		}
+	}
+	return WithKind(ErrorKindFetch, fmt.Errorf("failed to pull '%s': %w", ref, err))
+}
+
+// validatePublishTarget checks that ref names a repository and a tag to push to.
+func validatePublishTarget(ref string) error {
+	parsed, err := registry.ParseReference(ref)
+	if err != nil {
+		return fmt.Errorf("invalid OCI reference '%s': %w", ref, err)
+	}
+	if err := parsed.ValidateReferenceAsTag(); err != nil {
+		return fmt.Errorf("OCI reference '%s' must end in a tag to publish to, e.g. ':1.4.0'", ref)
+	}
+	return nil
+}
+
+// ociLayer is a directory tree packed into one layer of a published artifact.
+type ociLayer struct {
+	mediaType string
+	title     string
+	root      string
+	files     []string // slash-separated paths relative to root, in the order they are packed
+}
+
+// publishOCIArtifact packs the layers into an artifact, pushes it to every target and returns
+// its manifest digest.
+func publishOCIArtifact(ctx context.Context, logger *slog.Logger, layers []ociLayer, targets []string, plainHTTP bool) (string, error) {
+	store := memory.New()
+	var descs []ocispec.Descriptor
+	for _, layer := range layers {
+		blob, err := tarGz(layer.root, layer.files)
+		if err != nil {
+			return "", fmt.Errorf("failed to pack '%s': %w", layer.title, err)
+		}
+		desc := content.NewDescriptorFromBytes(layer.mediaType, blob)
+		desc.Annotations = map[string]string{ocispec.AnnotationTitle: layer.title}
+		if err := store.Push(ctx, desc, bytes.NewReader(blob)); err != nil {
+			return "", fmt.Errorf("failed to store '%s': %w", layer.title, err)
+		}
+		descs = append(descs, desc)
+	}
+
+	manifestDesc, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, ociArtifactType, oras.PackManifestOptions{Layers: descs})
+	if err != nil {
+		return "", fmt.Errorf("failed to pack OCI manifest: %w", err)
+	}
+
+	for _, target := range targets {
+		repo, err := newOCIRepository(target, plainHTTP)
+		if err != nil {
+			return "", err
+		}
+		if err := oras.CopyGraph(ctx, store, repo, manifestDesc, oras.DefaultCopyGraphOptions); err != nil {
+			return "", ociPushError(target, err)
+		}
+		if err := repo.Tag(ctx, manifestDesc, repo.Reference.Reference); err != nil {
+			return "", ociPushError(target, err)
+		}
+		logger.Info("published OCI artifact", "reference", target, "digest", manifestDesc.Digest)
+	}
+	return manifestDesc.Digest.String(), nil
+}
+
+func ociPushError(ref string, err error) error {
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) && (errResp.StatusCode == http.StatusUnauthorized || errResp.StatusCode == http.StatusForbidden) {
+		return WithKind(ErrorKindAuth, fmt.Errorf("registry rejected the credentials for '%s', log in with 'docker login' or 'oras login': %w", ref, err))
+	}
+	return fmt.Errorf("failed to push to '%s': %w", ref, err)
+}
+
+// tarGz packs files below root into a gzip-compressed tar. Entries have no timestamps or owners,
+// so the same files always produce the same layer digest.
+func tarGz(root string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, rel := range files {
+		file, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: rel, Mode: 0644, Size: info.Size()}
+		if err := tw.WriteHeader(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
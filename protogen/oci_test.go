@@ -0,0 +1,214 @@
+package protogen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// fakeRegistry is an in-memory registry implementing the parts of the OCI distribution API
+// that pushing and pulling artifacts use: monolithic blob uploads, blobs and manifests. It
+// serves the error cases; the round trip is tested against a real registry.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte       // digest -> content
+	manifests map[string]fakeManifest // repository@reference, by tag and by digest -> manifest
+	uploads   int
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string]fakeManifest{}}
+}
+
+var registryPathRe = regexp.MustCompile(`^/v2/(.+)/(blobs/uploads|blobs|manifests)/(.*)$`)
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/v2/" {
+		return
+	}
+	m := registryPathRe.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		http.NotFound(w, req)
+		return
+	}
+	repo, endpoint, ref := m[1], m[2], m[3]
+
+	switch {
+	case endpoint == "blobs/uploads" && req.Method == http.MethodPost:
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+
+	case endpoint == "blobs/uploads" && req.Method == http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		d := req.URL.Query().Get("digest")
+		if digest.FromBytes(content).String() != d {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		r.blobs[d] = content
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, d))
+		w.WriteHeader(http.StatusCreated)
+
+	case endpoint == "blobs":
+		content, ok := r.blobs[ref]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		r.serveContent(w, req, "application/octet-stream", ref, content)
+
+	case endpoint == "manifests" && req.Method == http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		d := digest.FromBytes(content).String()
+		manifest := fakeManifest{mediaType: req.Header.Get("Content-Type"), content: content}
+		r.manifests[repo+"@"+ref] = manifest
+		r.manifests[repo+"@"+d] = manifest
+		w.Header().Set("Docker-Content-Digest", d)
+		w.WriteHeader(http.StatusCreated)
+
+	case endpoint == "manifests":
+		manifest, ok := r.manifests[repo+"@"+ref]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		r.serveContent(w, req, manifest.mediaType, digest.FromBytes(manifest.content).String(), manifest.content)
+
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) serveContent(w http.ResponseWriter, req *http.Request, mediaType, d string, content []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", d)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if req.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
+
+// startRegistry starts a registry:2 container and returns its host:port, skipping the test when
+// Docker is not available.
+func startRegistry(t *testing.T) string {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	registry, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "registry:2",
+			ExposedPorts: []string{"5000/tcp"},
+			WaitingFor:   wait.ForHTTP("/v2/").WithPort("5000/tcp"),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := registry.PortEndpoint(ctx, "5000/tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return endpoint
+}
+
+func TestPublishAndPullOCIArtifact(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	repository := startRegistry(t) + "/schemas/events"
+	ctx := context.Background()
+
+	srcDir := t.TempDir()
+	publish := func(content string) string {
+		t.Helper()
+		writeFiles(t, srcDir, map[string]string{"acme/orders.proto": content})
+		g := New(Options{Sources: []Source{{Type: SourceTypeLocal, Path: srcDir}}, OCIPlainHTTP: true})
+		result, err := g.Publish(ctx, PublishOptions{Targets: []string{repository + ":latest"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Digest
+	}
+
+	v1Digest := publish("v1")
+	v2Digest := publish("v2")
+	if v1Digest == v2Digest {
+		t.Fatalf("got the same digest %s for different content", v1Digest)
+	}
+
+	tests := []struct {
+		name, ref, digest, content string
+	}{
+		{name: "tag", ref: repository + ":latest", digest: v2Digest, content: "v2"},
+		{name: "pinned digest", ref: repository + "@" + v1Digest, digest: v1Digest, content: "v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(Options{Sources: []Source{{Type: SourceTypeOCI, Path: tt.ref}}, OCIPlainHTTP: true})
+			dir := t.TempDir()
+			result, err := g.Fetch(ctx, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dir, map[string]string{"acme/orders.proto": tt.content})
+			if got := result.Sources[0].Digest; got != tt.digest {
+				t.Errorf("got digest %s, want %s", got, tt.digest)
+			}
+		})
+	}
+}
+
+func TestPullOCIArtifactErrors(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+	repository := strings.TrimPrefix(server.URL, "http://") + "/schemas/events"
+	ctx := context.Background()
+
+	srcDir := t.TempDir()
+	writeFiles(t, srcDir, map[string]string{"acme/orders.proto": "v1"})
+	g := New(Options{Sources: []Source{{Type: SourceTypeLocal, Path: srcDir}}, OCIPlainHTTP: true})
+	if _, err := g.Publish(ctx, PublishOptions{Targets: []string{repository + ":latest"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		ref       string
+		plainHTTP bool
+	}{
+		{name: "unknown digest", ref: repository + "@" + digest.FromString("missing").String(), plainHTTP: true},
+		// The registry only speaks plain HTTP, so the default HTTPS requests fail.
+		{name: "without plain HTTP", ref: repository + ":latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(Options{Sources: []Source{{Type: SourceTypeOCI, Path: tt.ref}}, OCIPlainHTTP: tt.plainHTTP})
+			_, err := g.Fetch(ctx, t.TempDir())
+			if KindOf(err) != ErrorKindFetch {
+				t.Errorf("got error %v of kind %q, want kind %q", err, KindOf(err), ErrorKindFetch)
+			}
+		})
+	}
+}
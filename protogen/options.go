@@ -0,0 +1,294 @@
+package protogen
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	bufYamlFileName      = "buf.yaml"
+	bufGenGoYamlFileName = "buf.gen.go.yaml"
+	bufGenJsYamlFileName = "buf.gen.js.yaml"
+)
+
+//go:embed buf/buf.yaml
+var f embed.FS
+var bufYamlContent, _ = f.ReadFile("buf/buf.yaml")
+
+//go:embed buf/buf.gen.go.yaml
+var f1 embed.FS
+var bufGenGoYamlContent, _ = f1.ReadFile("buf/buf.gen.go.yaml")
+
+//go:embed buf/buf.gen.js.yaml
+var f2 embed.FS
+var bufGenJsYamlContent, _ = f2.ReadFile("buf/buf.gen.js.yaml")
+
+// DefaultBufConfigs returns the embedded buf configs keyed by their file name, e.g. to write
+// editable copies of them.
+func DefaultBufConfigs() map[string][]byte {
+	return map[string][]byte{
+		bufYamlFileName:      bufYamlContent,
+		bufGenGoYamlFileName: bufGenGoYamlContent,
+		bufGenJsYamlFileName: bufGenJsYamlContent,
+	}
+}
+
+type githubAuthMethod string
+
+const (
+	githubAuthSSH   githubAuthMethod = "ssh"
+	githubAuthToken githubAuthMethod = "token"
+	githubAuthApp   githubAuthMethod = "github-app"
+)
+
+// Options configures a Generator. The fields mirror the command line flags of git-proto-gen.
+type Options struct {
+	// Sources are the proto sources, mounted into one workspace in this order.
+	Sources []Source
+	// Languages to generate code for: go, js.
+	Languages []string
+	// OutputPath is the output directory layout, may contain {lang}, {source} and {package}.
+	OutputPath string
+	// LanguageOutputs overrides OutputPath per language.
+	LanguageOutputs map[string]string
+	// OutputRoot is the directory the output layouts and the generation manifest are relative
+	// to. Defaults to the working directory.
+	OutputRoot string
+	// GithubToken authenticates GitHub API requests. When empty, a token is looked up in
+	// GITHUB_TOKEN, GH_TOKEN, HostTokens, ~/.netrc and git credential helpers.
+	GithubToken string
+	// GithubTokenSource describes where GithubToken came from, for logs and doctor checks.
+	GithubTokenSource string
+	// HostTokens maps a GitHub host to its token.
+	HostTokens   map[string]string
+	GithubApp    GithubAppOptions
+	GithubAPIURL string
+	SSH          SSHOptions
+	// Container configures the container buf runs in.
+	Container ContainerOptions
+	// ContainerOutput receives the output of the commands run in the generator container while
+	// they run, every line prefixed with the language or check and the step, e.g.
+	// "[js install npm packages] ". The output is discarded when it is nil.
+	ContainerOutput io.Writer
+	// ContainerLogPath is the file ContainerOutput saves the output to, if any. Errors of failed
+	// container commands only include the last lines of their output and refer to it for the rest.
+	ContainerLogPath string
+	// OCIPlainHTTP uses plain HTTP instead of HTTPS for OCI registries.
+	OCIPlainHTTP bool
+	// BufConfigsPath is a directory with buf.yaml, buf.gen.go.yaml or buf.gen.js.yaml files
+	// used instead of the embedded ones.
+	BufConfigsPath string
+	GoModule       string
+	VerifyGoModule bool
+	NpmPackage     NpmPackage
+	// Fetchers replaces the built-in fetcher for the sources of a type, e.g. to read them from a
+	// mirror or an in-memory fake.
+	Fetchers map[SourceType]Fetcher
+	// Logger receives the progress reports of the generator. Defaults to slog.Default().
+	Logger *slog.Logger
+
+	githubAuthMethod githubAuthMethod
+	githubApp        *githubApp
+	// githubQuota is the last rate limit GitHub reported, shared by all clients of a Generator.
+	githubQuota *rateLimitQuota
+	bufConfigs  bufConfigs
+}
+
+// logger returns the logger of the options, slog.Default() when none is set.
+func (cfg *Options) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return slog.Default()
+	}
+	return cfg.Logger
+}
+
+// withDefaults fills in the defaults of the options left empty.
+func (cfg *Options) withDefaults() error {
+	if cfg.OutputRoot == "" {
+		cfg.OutputRoot = "."
+	}
+	absOutputRoot, err := filepath.Abs(cfg.OutputRoot)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for output directory '%s': %w", cfg.OutputRoot, err)
+	}
+	cfg.OutputRoot = absOutputRoot
+
+	if cfg.GithubAPIURL == "" {
+		cfg.GithubAPIURL = DefaultGithubAPIURL
+	}
+	if cfg.SSH.Host == "" {
+		cfg.SSH.Host = DefaultSSHHost
+	}
+	if cfg.NpmPackage.Version == "" {
+		cfg.NpmPackage.Version = "0.0.0"
+	}
+	cfg.Container.withDefaults()
+	return nil
+}
+
+// resolveSources validates the sources and selects the authentication method for private sources.
+func (cfg *Options) resolveSources(ctx context.Context) error {
+	if len(cfg.Sources) == 0 {
+		return errors.New("at least one source is required")
+	}
+
+	if err := normalizeSources(cfg.Sources); err != nil {
+		return err
+	}
+
+	hasPrivateSources, hasPublicSources := false, false
+	for i := range cfg.Sources {
+		if _, ok := cfg.Fetchers[cfg.Sources[i].Type]; ok {
+			continue
+		}
+		hasPrivateSources = hasPrivateSources || cfg.Sources[i].Type == SourceTypePrivate
+		hasPublicSources = hasPublicSources || cfg.Sources[i].Type == SourceTypePublic
+	}
+
+	// Public sources use a token when one is available, for its higher rate limit, also when a
+	// GitHub App authenticates the private sources.
+	if hasPublicSources || (hasPrivateSources && !cfg.GithubApp.enabled()) {
+		if err := cfg.resolveGithubToken(ctx); err != nil {
+			return WithKind(ErrorKindAuth, err)
+		}
+	}
+
+	if hasPrivateSources && cfg.GithubApp.enabled() {
+		app, err := newGithubApp(cfg)
+		if err != nil {
+			return err
+		}
+		cfg.githubApp = app
+		cfg.githubAuthMethod = githubAuthApp
+		cfg.logger().Info("using GitHub App authentication", "app_id", cfg.GithubApp.AppID)
+	} else if hasPrivateSources {
+		if cfg.GithubToken != "" {
+			cfg.githubAuthMethod = githubAuthToken
+		} else {
+			if err := cfg.SSH.normalize(); err != nil {
+				return err
+			}
+			if err := checkSSHAuth(cfg.logger(), cfg.SSH); err != nil {
+				return WithKind(ErrorKindAuth, fmt.Errorf("you must provide a GitHub token with --token for private repos or have SSH keys configured: %w", err))
+			}
+			cfg.githubAuthMethod = githubAuthSSH
+		}
+	}
+
+	cfg.bufConfigs = loadBufConfigs(cfg.logger(), cfg.BufConfigsPath)
+	if err := cfg.Container.validate(cfg.bufConfigs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// resolveGithubToken discovers a GitHub token when none was given.
+func (cfg *Options) resolveGithubToken(ctx context.Context) error {
+	if cfg.GithubToken != "" {
+		if cfg.GithubTokenSource == "" {
+			cfg.GithubTokenSource = "options"
+		}
+	} else {
+		token, source, err := discoverGithubToken(ctx, cfg.logger(), githubHostFor(cfg.GithubAPIURL), cfg.HostTokens)
+		if err != nil {
+			return err
+		}
+		cfg.GithubToken, cfg.GithubTokenSource = token, source
+	}
+
+	if cfg.GithubToken != "" {
+		cfg.logger().Info("using GitHub token", "source", cfg.GithubTokenSource)
+	}
+	return nil
+}
+
+// Validate checks the options with the rules Generate applies, without fetching anything.
+func (cfg Options) Validate() error {
+	if err := cfg.withDefaults(); err != nil {
+		return err
+	}
+	cfg.Sources = slices.Clone(cfg.Sources)
+	if err := normalizeSources(cfg.Sources); err != nil {
+		return err
+	}
+	if err := cfg.Container.validate(loadBufConfigs(cfg.logger(), cfg.BufConfigsPath)); err != nil {
+		return err
+	}
+	return cfg.validateGenerate()
+}
+
+// validateGenerate validates the options of the generate command.
+func (cfg *Options) validateGenerate() error {
+	allowed := map[string]bool{"go": true, "js": true}
+	for _, lang := range cfg.Languages {
+		if !allowed[lang] {
+			return fmt.Errorf("invalid language '%s'. Allowed values: go, js", lang)
+		}
+	}
+
+	if len(cfg.Languages) == 0 {
+		return errors.New("you must provide at least one --lang (go, js, or both)")
+	}
+
+	if !slices.ContainsFunc(cfg.Sources, func(s Source) bool { return !s.ImportOnly }) {
+		return errors.New("every source is import_only, so there is nothing to generate")
+	}
+
+	for lang := range cfg.LanguageOutputs {
+		if !allowed[lang] {
+			return fmt.Errorf("invalid language '%s' in output layouts. Allowed values: go, js", lang)
+		}
+	}
+	for _, lang := range cfg.Languages {
+		if err := validateLayout(cfg.outputLayout(lang)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.GoModule != "" {
+		if !slices.Contains(cfg.Languages, "go") {
+			return errors.New("--go-module requires the go language")
+		}
+		if err := validateGoModule(cfg.GoModule, cfg.outputLayout("go")); err != nil {
+			return err
+		}
+	}
+
+	if cfg.NpmPackage.Name != "" {
+		if !slices.Contains(cfg.Languages, "js") {
+			return errors.New("--npm-package requires the js language")
+		}
+		if err := validateNpmPackage(cfg.NpmPackage); err != nil {
+			return err
+		}
+	} else if cfg.NpmPackage.Pack {
+		return errors.New("--npm-pack requires --npm-package")
+	}
+
+	return nil
+}
+
+// outputLayout returns the output directory layout for lang.
+func (cfg *Options) outputLayout(lang string) string {
+	if layout := cfg.LanguageOutputs[lang]; layout != "" {
+		return layout
+	}
+	return cfg.OutputPath
+}
+
+// OutputDirs returns the fixed directory of the output layout of every language, relative to
+// the output root. Generated files are only ever written below these directories.
+func (cfg Options) OutputDirs() []string {
+	var dirs []string
+	for _, lang := range cfg.Languages {
+		dirs = append(dirs, layoutRoot(cfg.outputLayout(lang), lang))
+	}
+	return dirs
+}
@@ -0,0 +1,275 @@
+package protogen
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// stagedOutput is an output directory whose new content has been prepared in a staging
+// directory next to it, ready to be swapped into place.
+type stagedOutput struct {
+	target  string
+	staging string
+	backup  string
+	swapped bool
+}
+
+// outputTransaction replaces every output directory of a run at once. Until commit succeeds the
+// existing output is left untouched, and a failing commit restores the previous output.
+type outputTransaction struct {
+	root     string
+	outputs  []*stagedOutput
+	manifest *GenerationManifest
+	logger   *slog.Logger
+}
+
+// stageOutput prepares the new content of every output directory of the previous and current run.
+// Each staging directory starts as a link tree of the existing output directory, so files the tool
+// did not generate are kept as they are, then stale generated files are removed and the generated
+// files of all languages in generatedDirs are copied over it.
+func stageOutput(logger *slog.Logger, root string, generatedDirs []string, previous, current *GenerationManifest) (*outputTransaction, error) {
+	tx := &outputTransaction{root: root, manifest: current, logger: logger}
+
+	for _, dir := range outermostDirs(append(slices.Clone(current.Outputs), previous.Outputs...)) {
+		target, err := resolveOutputDir(filepath.Join(root, dir))
+		if err != nil {
+			tx.discard()
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			tx.discard()
+			return nil, fmt.Errorf("failed to create directory '%s': %w", filepath.Dir(target), err)
+		}
+
+		staging, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+".staging-")
+		if err != nil {
+			tx.discard()
+			return nil, fmt.Errorf("failed to create staging directory for '%s': %w", target, err)
+		}
+		output := &stagedOutput{target: target, staging: staging}
+		tx.outputs = append(tx.outputs, output)
+
+		if _, err := os.Stat(target); err == nil {
+			if err := linkExistingOutput(target, staging); err != nil {
+				tx.discard()
+				return nil, fmt.Errorf("failed to copy existing output '%s' to staging directory: %w", target, err)
+			}
+		} else if err := os.Chmod(staging, 0755); err != nil {
+			tx.discard()
+			return nil, fmt.Errorf("failed to set mode of staging directory for '%s': %w", target, err)
+		}
+
+		if err := removeStaleGeneratedFiles(logger, staging, previous.within(dir), current.within(dir)); err != nil {
+			tx.discard()
+			return nil, fmt.Errorf("failed to remove stale generated files: %w", err)
+		}
+
+		for _, generatedDir := range generatedDirs {
+			generatedOutput := filepath.Join(generatedDir, dir)
+			if _, err := os.Stat(generatedOutput); os.IsNotExist(err) {
+				continue
+			}
+			if err := copyGeneratedFiles(generatedOutput, staging); err != nil {
+				tx.discard()
+				return nil, fmt.Errorf("failed to copy generated files to staging directory: %w", err)
+			}
+		}
+	}
+
+	return tx, nil
+}
+
+// resolveOutputDir returns the directory an output directory symlink points to, so that the
+// staging directory is swapped in place of the target and the symlink itself is kept. Other paths,
+// including ones that do not exist yet, are returned as they are.
+func resolveOutputDir(target string) (string, error) {
+	info, err := os.Lstat(target)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return target, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output directory symlink '%s': %w", target, err)
+	}
+	return resolved, nil
+}
+
+// linkExistingOutput recreates the existing output directory srcDir in dstDir without copying
+// file contents: regular files are hard-linked, which keeps their mode, owner and modification
+// time, symlinks are recreated as they are, without following them, and directories keep their
+// mode. Files are copied, with their mode, where hard links are not supported.
+func linkExistingOutput(srcDir, dstDir string) error {
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from '%s': %w", path, srcDir, err)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(dstDir, relPath)
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink '%s': %w", path, err)
+			}
+			return os.Symlink(link, targetPath)
+
+		case info.IsDir():
+			if relPath != "." {
+				if err := os.Mkdir(targetPath, 0700); err != nil {
+					return fmt.Errorf("failed to create directory '%s': %w", targetPath, err)
+				}
+			}
+			return os.Chmod(targetPath, info.Mode().Perm())
+
+		case info.Mode().IsRegular():
+			if err := os.Link(path, targetPath); err == nil {
+				return nil
+			}
+			if err := copyFile(path, targetPath); err != nil {
+				return err
+			}
+			return os.Chmod(targetPath, info.Mode().Perm())
+
+		default:
+			return fmt.Errorf("'%s' is a %s, which cannot be kept when replacing the output directory", path, info.Mode().Type())
+		}
+	})
+}
+
+// outermostDirs sorts and deduplicates dirs, dropping directories nested inside another one,
+// so that every file is staged exactly once.
+func outermostDirs(dirs []string) []string {
+	cleaned := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		cleaned = append(cleaned, filepath.Clean(dir))
+	}
+	slices.Sort(cleaned)
+
+	var outermost []string
+	for _, dir := range cleaned {
+		nested := slices.ContainsFunc(outermost, func(outer string) bool {
+			return dir == outer || strings.HasPrefix(dir, outer+string(filepath.Separator))
+		})
+		if !nested {
+			outermost = append(outermost, dir)
+		}
+	}
+	return outermost
+}
+
+// commit swaps every staged directory into place and writes the generation manifest. On failure
+// all directories swapped so far are restored from their backups.
+func (tx *outputTransaction) commit() error {
+	manifestFile, err := os.CreateTemp(tx.root, generationManifestFileName+".tmp-")
+	if err != nil {
+		tx.discard()
+		return fmt.Errorf("failed to create temporary generation manifest: %w", err)
+	}
+	manifestFile.Close()
+	defer os.Remove(manifestFile.Name())
+
+	if err := tx.manifest.writeFile(manifestFile.Name()); err != nil {
+		tx.discard()
+		return err
+	}
+
+	for _, output := range tx.outputs {
+		if err := output.swap(); err != nil {
+			tx.rollback()
+			return fmt.Errorf("failed to replace output directory '%s': %w", output.target, err)
+		}
+	}
+
+	if err := os.Rename(manifestFile.Name(), filepath.Join(tx.root, generationManifestFileName)); err != nil {
+		tx.rollback()
+		return fmt.Errorf("failed to write generation manifest: %w", err)
+	}
+
+	for _, output := range tx.outputs {
+		if output.backup != "" {
+			if err := os.RemoveAll(output.backup); err != nil {
+				tx.logger.Warn("failed to remove previous output backup", "path", output.backup, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// swap moves the current output directory aside and renames the staging directory into its place.
+// An empty staging directory means nothing is left to keep, so the output directory is removed.
+func (o *stagedOutput) swap() error {
+	if _, err := os.Stat(o.target); err == nil {
+		o.backup = o.staging + ".previous"
+		if err := os.Rename(o.target, o.backup); err != nil {
+			o.backup = ""
+			return err
+		}
+	}
+	o.swapped = true
+
+	entries, err := os.ReadDir(o.staging)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return os.Remove(o.staging)
+	}
+
+	return os.Rename(o.staging, o.target)
+}
+
+// rollback restores the previous content of every swapped output directory and removes all
+// staging directories.
+func (tx *outputTransaction) rollback() {
+	for i := len(tx.outputs) - 1; i >= 0; i-- {
+		output := tx.outputs[i]
+		if !output.swapped {
+			continue
+		}
+
+		if _, err := os.Stat(output.target); err == nil {
+			if err := os.RemoveAll(output.target); err != nil {
+				tx.logger.Error("failed to remove partially replaced output directory", "path", output.target, "error", err)
+				continue
+			}
+		}
+		if output.backup != "" {
+			if err := os.Rename(output.backup, output.target); err != nil {
+				tx.logger.Error("failed to restore previous output directory", "path", output.target, "backup", output.backup, "error", err)
+				continue
+			}
+			output.backup = ""
+		}
+		output.swapped = false
+	}
+
+	tx.discard()
+}
+
+// discard removes the staging directories of all outputs that were not swapped into place.
+func (tx *outputTransaction) discard() {
+	for _, output := range tx.outputs {
+		if output.swapped {
+			continue
+		}
+		if err := os.RemoveAll(output.staging); err != nil {
+			tx.logger.Warn("failed to remove output staging directory", "path", output.staging, "error", err)
+		}
+	}
+}
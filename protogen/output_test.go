@@ -0,0 +1,231 @@
+package protogen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStageOutputKeepsFilesItDidNotCreate(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"gen/acme/orders.pb.go": "old orders",
+		"gen/scripts/build.sh":  "#!/bin/sh",
+		"gen/README.md":         "readme",
+	})
+	writeFiles(t, outside, map[string]string{"shared/notes.txt": "notes"})
+	if err := os.Chmod(filepath.Join(root, "gen/scripts/build.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("README.md", filepath.Join(root, "gen/LINK.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "shared"), filepath.Join(root, "gen/shared")); err != nil {
+		t.Fatal(err)
+	}
+
+	generatedDir := t.TempDir()
+	writeFiles(t, generatedDir, map[string]string{"gen/acme/orders.pb.go": "new orders"})
+	previous := &GenerationManifest{Outputs: []string{"gen"}, Files: map[string]string{"gen/acme/orders.pb.go": hashString("old orders")}}
+	current := &GenerationManifest{Outputs: []string{"gen"}, Files: map[string]string{"gen/acme/orders.pb.go": hashString("new orders")}}
+
+	tx, err := stageOutput(slog.Default(), root, []string{generatedDir}, previous, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "gen/scripts/build.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("got mode %v for build.sh, want 0755", info.Mode().Perm())
+	}
+	for link, want := range map[string]string{"gen/LINK.md": "README.md", "gen/shared": filepath.Join(outside, "shared")} {
+		got, err := os.Readlink(filepath.Join(root, link))
+		if err != nil || got != want {
+			t.Errorf("got symlink %s -> %q (%v), want -> %q", link, got, err, want)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(root, "gen/acme/orders.pb.go"))
+	if err != nil || string(content) != "new orders" {
+		t.Errorf("got orders.pb.go %q (%v), want the new content", content, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(outside, "shared/notes.txt")); err != nil || string(content) != "notes" {
+		t.Errorf("got notes.txt behind the directory symlink %q (%v), want it untouched", content, err)
+	}
+}
+
+func TestStageOutputThroughSymlinkedOutputDir(t *testing.T) {
+	root := t.TempDir()
+	shared := t.TempDir()
+	writeFiles(t, shared, map[string]string{
+		"acme/orders.pb.go": "old orders",
+		"README.md":         "readme",
+	})
+	if err := os.Symlink(shared, filepath.Join(root, "gen")); err != nil {
+		t.Fatal(err)
+	}
+
+	generatedDir := t.TempDir()
+	writeFiles(t, generatedDir, map[string]string{"gen/acme/orders.pb.go": "new orders"})
+	previous := &GenerationManifest{Outputs: []string{"gen"}, Files: map[string]string{"gen/acme/orders.pb.go": hashString("old orders")}}
+	current := &GenerationManifest{Outputs: []string{"gen"}, Files: map[string]string{"gen/acme/orders.pb.go": hashString("new orders")}}
+
+	tx, err := stageOutput(slog.Default(), root, []string{generatedDir}, previous, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.Readlink(filepath.Join(root, "gen")); err != nil || got != shared {
+		t.Errorf("got output directory symlink -> %q (%v), want it kept -> %q", got, err, shared)
+	}
+	assertTree(t, shared, map[string]string{
+		"acme/orders.pb.go": "new orders",
+		"README.md":         "readme",
+	})
+}
+
+func TestStageOutputDiscardLeavesOutputUntouched(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"gen/acme/orders.pb.go": "old orders"})
+
+	generatedDir := t.TempDir()
+	writeFiles(t, generatedDir, map[string]string{"gen/acme/orders.pb.go": "new orders"})
+	current := &GenerationManifest{Outputs: []string{"gen"}, Files: map[string]string{"gen/acme/orders.pb.go": hashString("new orders")}}
+
+	// Staged files are hard links to the output, so replacing them must not write through.
+	tx, err := stageOutput(slog.Default(), root, []string{generatedDir}, &GenerationManifest{}, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.discard()
+
+	assertTree(t, root, map[string]string{"gen/acme/orders.pb.go": "old orders"})
+}
+
+func hashString(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRemoveStaleGeneratedFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// outputDir is the output directory below the temp dir the manifests are relative to.
+		outputDir         string
+		files             map[string]string
+		previous, current map[string]string
+		want              map[string]string
+	}{
+		{
+			name:     "file no longer generated",
+			files:    map[string]string{"acme/orders.pb.go": "orders", "acme/users.pb.go": "users"},
+			previous: map[string]string{"acme/orders.pb.go": hashString("orders"), "acme/users.pb.go": hashString("users")},
+			current:  map[string]string{"acme/users.pb.go": hashString("new users")},
+			want:     map[string]string{"acme/users.pb.go": "users"},
+		},
+		{
+			name:     "user files are kept",
+			files:    map[string]string{"acme/orders.pb.go": "orders", "acme/orders_ext.go": "ext", "go.mod": "module acme"},
+			previous: map[string]string{"acme/orders.pb.go": hashString("orders")},
+			current:  map[string]string{},
+			want:     map[string]string{"acme/orders_ext.go": "ext", "go.mod": "module acme"},
+		},
+		{
+			name:     "file modified after generation is kept",
+			files:    map[string]string{"acme/orders.pb.go": "orders with a local patch"},
+			previous: map[string]string{"acme/orders.pb.go": hashString("orders")},
+			current:  map[string]string{},
+			want:     map[string]string{"acme/orders.pb.go": "orders with a local patch"},
+		},
+		{
+			name:     "emptied directories are removed",
+			files:    map[string]string{"acme/v1/orders.pb.go": "orders", "acme/v2/orders.pb.go": "orders", "acme/v2/NOTES.md": "notes"},
+			previous: map[string]string{"acme/v1/orders.pb.go": hashString("orders"), "acme/v2/orders.pb.go": hashString("orders")},
+			current:  map[string]string{},
+			want:     map[string]string{"acme/v2/NOTES.md": "notes"},
+		},
+		{
+			name:      "entries outside the output directory are ignored",
+			outputDir: "gen",
+			files:     map[string]string{"gen/acme/orders.pb.go": "orders", "orders.pb.go": "orders"},
+			previous:  map[string]string{"../orders.pb.go": hashString("orders")},
+			current:   map[string]string{},
+			want:      map[string]string{"gen/acme/orders.pb.go": "orders", "orders.pb.go": "orders"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files)
+			outputRoot := filepath.Join(root, tt.outputDir)
+
+			previous := &GenerationManifest{Files: tt.previous}
+			current := &GenerationManifest{Files: tt.current}
+			if err := removeStaleGeneratedFiles(slog.Default(), outputRoot, previous, current); err != nil {
+				t.Fatal(err)
+			}
+
+			assertTree(t, root, tt.want)
+			if _, err := os.Stat(filepath.Join(root, "acme/v1")); err == nil {
+				t.Errorf("got directory acme/v1 left behind, want it removed once empty")
+			}
+		})
+	}
+}
+
+func TestCommitRestoresOutputWhenSwapFails(t *testing.T) {
+	root := t.TempDir()
+	original := map[string]string{
+		"gen/go/acme/orders.pb.go": "old go orders",
+		"gen/ts/acme/orders_pb.ts": "old ts orders",
+		"gen/ts/package.json":      "{}",
+		generationManifestFileName: "previous manifest",
+	}
+	writeFiles(t, root, original)
+
+	generatedDir := t.TempDir()
+	writeFiles(t, generatedDir, map[string]string{
+		"gen/go/acme/orders.pb.go": "new go orders",
+		"gen/ts/acme/orders_pb.ts": "new ts orders",
+	})
+	previous := &GenerationManifest{Outputs: []string{"gen/go", "gen/ts"}, Files: map[string]string{
+		"gen/go/acme/orders.pb.go": hashString("old go orders"),
+		"gen/ts/acme/orders_pb.ts": hashString("old ts orders"),
+	}}
+	current := &GenerationManifest{Outputs: []string{"gen/go", "gen/ts"}, Files: map[string]string{
+		"gen/go/acme/orders.pb.go": hashString("new go orders"),
+		"gen/ts/acme/orders_pb.ts": hashString("new ts orders"),
+	}}
+
+	tx, err := stageOutput(slog.Default(), root, []string{generatedDir}, previous, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.outputs) != 2 {
+		t.Fatalf("got %d staged outputs, want 2", len(tx.outputs))
+	}
+	// Losing the second staging directory makes its swap fail after the first output and the
+	// second output's previous content have already been moved aside.
+	if err := os.RemoveAll(tx.outputs[1].staging); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.commit(); err == nil {
+		t.Fatal("got no error, want the swap of gen/ts to fail")
+	}
+
+	assertTree(t, root, original)
+}
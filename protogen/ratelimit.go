@@ -0,0 +1,202 @@
+package protogen
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	githubMaxRetries = 5
+	// githubMaxBackoff caps the exponential backoff between two attempts.
+	githubMaxBackoff = 30 * time.Second
+	// githubMaxRateLimitWait is the longest the client waits for a rate limit to reset. Longer
+	// waits fail the request, so a run does not hang for up to an hour.
+	githubMaxRateLimitWait = 2 * time.Minute
+	// githubLowQuotaRatio is the share of the quota below which a warning is logged.
+	githubLowQuotaRatio = 0.1
+)
+
+// rateLimitQuota is the last rate limit GitHub reported. A nil quota records nothing.
+type rateLimitQuota struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	warned    bool
+	seen      bool
+}
+
+// update records the rate limit headers of resp, warning once when the quota runs low.
+func (q *rateLimitQuota) update(logger *slog.Logger, resp *http.Response) {
+	if q == nil {
+		return
+	}
+	limit, err1 := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err1 != nil || err2 != nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit, q.remaining, q.seen = limit, remaining, true
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		q.reset = time.Unix(reset, 0)
+	}
+
+	if !q.warned && float64(remaining) < float64(limit)*githubLowQuotaRatio {
+		q.warned = true
+		logger.Warn("GitHub API quota is running low", "remaining", remaining, "limit", limit, "reset", q.reset)
+	}
+}
+
+// report logs the remaining quota, if any GitHub request was made.
+func (q *rateLimitQuota) report(logger *slog.Logger) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.seen {
+		logger.Info("GitHub API quota", "remaining", q.remaining, "limit", q.limit, "reset", q.reset)
+	}
+}
+
+// retryTransport retries idempotent GitHub requests that failed on a rate limit, a transient
+// server error or a network error. It waits as long as Retry-After or X-RateLimit-Reset ask for,
+// and otherwise backs off exponentially with jitter. A request body is replayed from GetBody on
+// every attempt; requests whose body cannot be replayed are sent only once. The rate limit of
+// every response is recorded in quota.
+type retryTransport struct {
+	base   http.RoundTripper
+	sleep  func(req *http.Request, d time.Duration) error
+	logger *slog.Logger
+	quota  *rateLimitQuota
+}
+
+func newRetryTransport(base http.RoundTripper, logger *slog.Logger, quota *rateLimitQuota) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, sleep: sleepContext, logger: logger, quota: quota}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	retryable := isIdempotent(req.Method) && (!hasBody || req.GetBody != nil)
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if hasBody && attempt > 0 {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if resp != nil {
+			t.quota.update(t.logger, resp)
+		}
+		if !retryable || attempt == githubMaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait, retry := retryDelay(resp, err, attempt, time.Now())
+		if !retry {
+			return resp, err
+		}
+
+		t.logger.Warn("retrying GitHub request", "url", req.URL.Redacted(), "status", statusOf(resp), "error", err, "attempt", attempt+1, "wait", wait)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err := t.sleep(req, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isIdempotent reports whether sending a request with method twice has the same effect as once.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay decides whether a response or error is worth retrying and how long to wait first.
+func retryDelay(resp *http.Response, err error, attempt int, now time.Time) (time.Duration, bool) {
+	if err != nil {
+		return backoff(attempt), true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusForbidden:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait := time.Duration(seconds) * time.Second
+			return wait, wait <= githubMaxRateLimitWait
+		}
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			wait := time.Unix(reset, 0).Sub(now) + time.Second
+			return max(wait, 0), wait <= githubMaxRateLimitWait
+		}
+		// A 403 without rate limit headers is a permission error; a 429 without them is retried.
+		return backoff(attempt), resp.StatusCode == http.StatusTooManyRequests
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return backoff(attempt), true
+	default:
+		return 0, false
+	}
+}
+
+// backoff returns an exponential delay for attempt, of which a random half is jitter.
+func backoff(attempt int) time.Duration {
+	d := min(time.Second<<attempt, githubMaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleepContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// isGithubRateLimited reports whether GitHub refused a request over a rate limit, which the retry
+// transport gave up waiting for.
+func isGithubRateLimited(resp *github.Response, err error) bool {
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) {
+		return true
+	}
+	return err != nil && resp != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		(resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0")
+}
@@ -0,0 +1,254 @@
+package protogen
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rateLimitResponse(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	reset := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	tests := []struct {
+		name      string
+		resp      *http.Response
+		err       error
+		attempt   int
+		wantRetry bool
+		// wantWait is the exact delay; when wantMax is set the delay is a backoff between wantMin
+		// and wantMax instead.
+		wantWait         time.Duration
+		wantMin, wantMax time.Duration
+	}{
+		{
+			name:      "Retry-After",
+			resp:      rateLimitResponse(http.StatusForbidden, map[string]string{"Retry-After": "30"}),
+			wantRetry: true,
+			wantWait:  30 * time.Second,
+		},
+		{
+			name:      "Retry-After on 429",
+			resp:      rateLimitResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "5"}),
+			wantRetry: true,
+			wantWait:  5 * time.Second,
+		},
+		{
+			name:     "Retry-After too long",
+			resp:     rateLimitResponse(http.StatusForbidden, map[string]string{"Retry-After": "3600"}),
+			wantWait: time.Hour,
+		},
+		{
+			name:      "X-RateLimit-Reset",
+			resp:      rateLimitResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset(time.Minute)}),
+			wantRetry: true,
+			wantWait:  time.Minute + time.Second,
+		},
+		{
+			name:      "X-RateLimit-Reset in the past",
+			resp:      rateLimitResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset(-time.Minute)}),
+			wantRetry: true,
+		},
+		{
+			name:     "X-RateLimit-Reset too far away",
+			resp:     rateLimitResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset(time.Hour)}),
+			wantWait: time.Hour + time.Second,
+		},
+		{
+			name:    "403 without rate limit headers",
+			resp:    rateLimitResponse(http.StatusForbidden, nil),
+			wantMin: 500 * time.Millisecond,
+			wantMax: time.Second,
+		},
+		{
+			name:      "429 without rate limit headers",
+			resp:      rateLimitResponse(http.StatusTooManyRequests, nil),
+			wantRetry: true,
+			wantMin:   500 * time.Millisecond,
+			wantMax:   time.Second,
+		},
+		{
+			name:      "502 on the first attempt",
+			resp:      rateLimitResponse(http.StatusBadGateway, nil),
+			wantRetry: true,
+			wantMin:   500 * time.Millisecond,
+			wantMax:   time.Second,
+		},
+		{
+			name:      "503 on the third attempt",
+			resp:      rateLimitResponse(http.StatusServiceUnavailable, nil),
+			attempt:   2,
+			wantRetry: true,
+			wantMin:   2 * time.Second,
+			wantMax:   4 * time.Second,
+		},
+		{
+			name:      "500 backoff is capped",
+			resp:      rateLimitResponse(http.StatusInternalServerError, nil),
+			attempt:   10,
+			wantRetry: true,
+			wantMin:   githubMaxBackoff / 2,
+			wantMax:   githubMaxBackoff,
+		},
+		{
+			name:      "network error",
+			err:       errors.New("connection reset by peer"),
+			wantRetry: true,
+			wantMin:   500 * time.Millisecond,
+			wantMax:   time.Second,
+		},
+		{
+			name: "404",
+			resp: rateLimitResponse(http.StatusNotFound, nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, retry := retryDelay(tt.resp, tt.err, tt.attempt, now)
+			if retry != tt.wantRetry {
+				t.Errorf("got retry %t, want %t", retry, tt.wantRetry)
+			}
+			if tt.wantMax == 0 {
+				if wait != tt.wantWait {
+					t.Errorf("got wait %s, want %s", wait, tt.wantWait)
+				}
+			} else if wait < tt.wantMin || wait > tt.wantMax {
+				t.Errorf("got wait %s, want between %s and %s", wait, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+// scriptedTransport answers requests with the given status codes in turn, repeating the last
+// one, and records the body of every request it receives.
+type scriptedTransport struct {
+	statuses []int
+	bodies   []string
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(content)
+	}
+	s.bodies = append(s.bodies, body)
+
+	status := s.statuses[min(len(s.bodies)-1, len(s.statuses)-1)]
+	return rateLimitResponse(status, nil), nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		// noReplay drops GetBody, as for a request built from a plain io.Reader.
+		noReplay bool
+		statuses []int
+		// wantBodies are the bodies of the attempts made.
+		wantBodies []string
+		wantStatus int
+	}{
+		{
+			name:       "GET is retried until it succeeds",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			wantBodies: []string{"", "", ""},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "retries are capped",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusBadGateway},
+			wantBodies: []string{"", "", "", "", "", ""},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "403 without rate limit headers is not retried",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusForbidden, http.StatusOK},
+			wantBodies: []string{""},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "PUT body is replayed",
+			method:     http.MethodPut,
+			body:       `{"content":"orders"}`,
+			statuses:   []int{http.StatusBadGateway, http.StatusOK},
+			wantBodies: []string{`{"content":"orders"}`, `{"content":"orders"}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "body that cannot be replayed is sent once",
+			method:     http.MethodPut,
+			body:       `{"content":"orders"}`,
+			noReplay:   true,
+			statuses:   []int{http.StatusBadGateway, http.StatusOK},
+			wantBodies: []string{`{"content":"orders"}`},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "POST is not retried",
+			method:     http.MethodPost,
+			body:       `{}`,
+			statuses:   []int{http.StatusBadGateway, http.StatusOK},
+			wantBodies: []string{`{}`},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &scriptedTransport{statuses: tt.statuses}
+			waits := 0
+			transport := &retryTransport{base: base, logger: slog.Default(), sleep: func(req *http.Request, d time.Duration) error {
+				waits++
+				return nil
+			}}
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(tt.method, "https://api.github.com/repos/acme/protos/contents/orders.proto", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.noReplay {
+				req.GetBody = nil
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(base.bodies) != len(tt.wantBodies) || strings.Join(base.bodies, "|") != strings.Join(tt.wantBodies, "|") {
+				t.Errorf("got attempts with bodies %q, want %q", base.bodies, tt.wantBodies)
+			}
+			if waits != len(tt.wantBodies)-1 {
+				t.Errorf("got %d waits, want %d", waits, len(tt.wantBodies)-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package protogen
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-github/v72/github"
+)
+
+// shortSHARe matches abbreviated commit SHAs, which can be resolved but not fetched directly.
+var shortSHARe = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
+// resolvedRef records what the ref of a remote source resolved to.
+type resolvedRef struct {
+	// Ref is the ref as requested, e.g. "main", "v1.4.0", "3f2c1e9" or "^1.4".
+	Ref string
+	// Version is the tag selected for a semver constraint, or "" for other refs.
+	Version string
+	Commit  string
+}
+
+// String describes the ref for logs and generated file headers, e.g. "^1.4 (v1.4.2, commit 3f2c1e9...)".
+func (r resolvedRef) String() string {
+	ref := r.Ref
+	if ref == "" {
+		ref = "default branch"
+	}
+	if r.Version != "" {
+		return fmt.Sprintf("%s (%s, commit %s)", ref, r.Version, r.Commit)
+	}
+	return fmt.Sprintf("%s (commit %s)", ref, r.Commit)
+}
+
+// isSemverConstraint reports whether ref can be read as a semver range such as "^1.4", "~1.2.3",
+// ">= 1.0, < 2" or "1.x". Exact versions like "v1.4.0" are taken as tag names. A branch or tag
+// named like a range, e.g. a "1.x" maintenance branch, takes precedence over the range, which
+// the resolvers check before selecting a tag.
+func isSemverConstraint(ref string) bool {
+	if !strings.ContainsAny(ref, "^~<>=*xX|, ") {
+		return false
+	}
+	_, err := semver.NewConstraint(ref)
+	return err == nil
+}
+
+// selectVersion returns the tag with the highest semver version satisfying constraint. Tags that
+// are not semver versions are ignored, and pre-releases only match constraints that name one.
+func selectVersion(constraint string, tags []string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint '%s': %w", constraint, err)
+	}
+
+	var bestTag string
+	var best *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestTag = v, tag
+		}
+	}
+
+	if best == nil {
+		return "", WithKind(ErrorKindFetch, fmt.Errorf("no tag satisfies version constraint '%s'", constraint))
+	}
+	return bestTag, nil
+}
+
+// resolveGithubRef resolves ref to a commit with the GitHub API, selecting the matching tag first
+// for semver constraints that are not the name of a branch or tag. Files are then read at the
+// commit, so every file of a source comes from the same snapshot even if the branch moves in the
+// meantime.
+func resolveGithubRef(ctx context.Context, logger *slog.Logger, client *github.Client, owner, repo, ref string) (resolvedRef, error) {
+	resolved := resolvedRef{Ref: ref}
+
+	target := ref
+	if isSemverConstraint(ref) {
+		commit, resp, err := client.Repositories.GetCommitSHA1(ctx, owner, repo, ref, "")
+		if err == nil {
+			resolved.Commit = commit
+			logger.Info("resolved ref", "repo", owner+"/"+repo, "ref", ref, "commit", commit)
+			return resolved, nil
+		}
+		if !isGithubRefNotFound(resp) {
+			return resolved, githubRefError(resp, err, owner, repo, ref)
+		}
+
+		tags, err := listGithubTags(ctx, client, owner, repo)
+		if err != nil {
+			return resolved, err
+		}
+		if resolved.Version, err = selectVersion(ref, tags); err != nil {
+			return resolved, err
+		}
+		target = resolved.Version
+	}
+	if target == "" {
+		target = "HEAD"
+	}
+
+	commit, resp, err := client.Repositories.GetCommitSHA1(ctx, owner, repo, target, "")
+	if err != nil {
+		return resolved, githubRefError(resp, err, owner, repo, target)
+	}
+	resolved.Commit = commit
+
+	logger.Info("resolved ref", "repo", owner+"/"+repo, "ref", ref, "version", resolved.Version, "commit", commit)
+	return resolved, nil
+}
+
+// isGithubRefNotFound reports whether GitHub answered a commit lookup with no matching ref.
+func isGithubRefNotFound(resp *github.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity)
+}
+
+// githubRefError classifies an error of resolving ref: rate limits and unknown refs are fetch
+// errors, rejected credentials auth errors.
+func githubRefError(resp *github.Response, err error, owner, repo, ref string) error {
+	switch {
+	case isGithubRateLimited(resp, err):
+		return WithKind(ErrorKindFetch, fmt.Errorf("GitHub rate limit exceeded while resolving ref '%s' of '%s/%s', use a token for a higher limit: %w", ref, owner, repo, err))
+	case resp != nil && resp.StatusCode == http.StatusUnauthorized:
+		return WithKind(ErrorKindAuth, fmt.Errorf("GitHub rejected the credentials for repository '%s/%s': %w", owner, repo, err))
+	case isGithubRefNotFound(resp):
+		return WithKind(ErrorKindFetch, fmt.Errorf("ref '%s' not found in repository '%s/%s', check that the branch, tag or commit SHA exists", ref, owner, repo))
+	default:
+		return fmt.Errorf("failed to resolve ref '%s' of repository '%s/%s': %w", ref, owner, repo, err)
+	}
+}
+
+func listGithubTags(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	var names []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		tags, resp, err := client.Repositories.ListTags(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of repository '%s/%s': %w", owner, repo, err)
+		}
+		for _, tag := range tags {
+			names = append(names, tag.GetName())
+		}
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// resolveGitRef resolves ref for a git checkout into dir, whose origin remote is already set: a
+// semver constraint that is not the name of a branch or tag becomes the matching tag and an
+// abbreviated SHA the full commit SHA, both of which git can fetch. Other refs are returned
+// unchanged.
+func resolveGitRef(ctx context.Context, opts SSHOptions, dir, ref string) (resolvedRef, string, error) {
+	resolved := resolvedRef{Ref: ref}
+
+	switch {
+	case isSemverConstraint(ref):
+		output, err := runGit(ctx, opts, dir, "ls-remote", "--heads", "--tags", "--refs", "origin")
+		if err != nil {
+			return resolved, "", err
+		}
+		var tags []string
+		for _, line := range strings.Split(output, "\n") {
+			_, name, _ := strings.Cut(line, "\t")
+			if name == "refs/heads/"+ref || name == "refs/tags/"+ref {
+				return resolved, ref, nil
+			}
+			if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+				tags = append(tags, tag)
+			}
+		}
+		if resolved.Version, err = selectVersion(ref, tags); err != nil {
+			return resolved, "", err
+		}
+		return resolved, resolved.Version, nil
+
+	case shortSHARe.MatchString(ref):
+		// Servers only serve full SHAs, so fetch the commit history without trees or blobs and
+		// look the commit up locally. Refs that merely look like SHAs are fetched by name.
+		output, err := runGit(ctx, opts, dir, "ls-remote", "origin", ref)
+		if err != nil {
+			return resolved, "", err
+		}
+		if output != "" {
+			return resolved, ref, nil
+		}
+		if _, err := runGit(ctx, opts, dir, "fetch", "--quiet", "--filter=tree:0", "origin"); err != nil {
+			return resolved, "", err
+		}
+		commit, err := runGit(ctx, opts, dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+		if err != nil {
+			return resolved, "", WithKind(ErrorKindFetch, fmt.Errorf("commit '%s' not found on any branch", ref))
+		}
+		return resolved, commit, nil
+
+	default:
+		if ref == "" {
+			return resolved, "HEAD", nil
+		}
+		return resolved, ref, nil
+	}
+}
@@ -0,0 +1,221 @@
+package protogen
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+type SourceType string
+
+const (
+	SourceTypeLocal   SourceType = "local"
+	SourceTypePublic  SourceType = "public"
+	SourceTypePrivate SourceType = "private"
+	SourceTypeArchive SourceType = "archive"
+	SourceTypeOCI     SourceType = "oci"
+)
+
+// RenameRule replaces a leading path segment of a source file, e.g. "v1" -> "greeting/v1".
+type RenameRule struct {
+	From string `yaml:"from,omitempty"`
+	To   string `yaml:"to,omitempty"`
+}
+
+// Mount describes where the files of a source land inside the workspace.
+// Paths are rewritten in order: strip_prefix, then the first matching rename rule, then prefix.
+type Mount struct {
+	Prefix      *string      `yaml:"prefix,omitempty"`
+	StripPrefix string       `yaml:"strip_prefix,omitempty"`
+	Rename      []RenameRule `yaml:"rename,omitempty"`
+}
+
+// Source is a single location contributing .proto files to the workspace.
+type Source struct {
+	Name  string     `yaml:"name,omitempty"`
+	Type  SourceType `yaml:"type"`
+	Path  string     `yaml:"path"`
+	Mount Mount      `yaml:"mount,omitempty"`
+	// Include and Exclude are doublestar glob patterns matched against the paths of the .proto
+	// files relative to the source path, e.g. "**/v1/*.proto" or "**/testdata/**".
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// ImportOnly sources are available for imports, but no code is generated for them.
+	ImportOnly bool `yaml:"import_only,omitempty"`
+	// SHA256 is the required checksum of an archive source, whose Path is a URL or a local file.
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Subdir selects the directory of an archive or OCI source the .proto files are taken from.
+	Subdir string `yaml:"subdir,omitempty"`
+
+	// resolved is what the ref of a remote source resolved to when it was fetched.
+	resolved resolvedRef
+	// digest is the manifest digest an OCI source was pulled at.
+	digest string
+	// mounted lists the workspace paths of the files the source contributed.
+	mounted []string
+}
+
+// normalize validates the source and fills in its default name and mount prefix.
+// Local sources mount at the workspace root, remote sources under their repository name.
+func (s *Source) normalize() error {
+	if s.Path == "" {
+		return fmt.Errorf("source '%s' has no path", s.Name)
+	}
+
+	var defaultName, defaultPrefix string
+	switch s.Type {
+	case SourceTypeLocal:
+		absPath, err := filepath.Abs(s.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for local proto path '%s': %w", s.Path, err)
+		}
+		defaultName = filepath.Base(absPath)
+	case SourceTypePublic, SourceTypePrivate:
+		_, repo, _, _, err := parseRepoPath(s.Path)
+		if err != nil {
+			return err
+		}
+		defaultName = repo
+		defaultPrefix = repo
+	case SourceTypeArchive:
+		if !sha256Re.MatchString(s.SHA256) {
+			return fmt.Errorf("archive source '%s' requires the sha256 checksum of the archive as 64 hex digits", s.Path)
+		}
+		defaultName = archiveName(s.Path)
+	case SourceTypeOCI:
+		name, err := ociName(s.Path)
+		if err != nil {
+			return err
+		}
+		defaultName = name
+	default:
+		return fmt.Errorf("invalid source type '%s' for '%s'. Allowed values: local, public, private, archive, oci", s.Type, s.Path)
+	}
+	if s.Subdir != "" && s.Type != SourceTypeArchive && s.Type != SourceTypeOCI {
+		return fmt.Errorf("source '%s': subdir is only supported for archive and oci sources", s.Path)
+	}
+
+	if s.Name == "" {
+		s.Name = defaultName
+	}
+	if s.Mount.Prefix == nil {
+		s.Mount.Prefix = &defaultPrefix
+	}
+
+	for _, p := range append([]string{*s.Mount.Prefix, s.Mount.StripPrefix, s.Subdir}, renamePaths(s.Mount.Rename)...) {
+		if path.IsAbs(p) || slices.Contains(strings.Split(path.Clean(p), "/"), "..") {
+			return fmt.Errorf("source '%s': mount path '%s' must be relative and stay inside the workspace", s.Name, p)
+		}
+	}
+
+	for _, pattern := range append(slices.Clone(s.Include), s.Exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("source '%s': invalid glob pattern '%s'", s.Name, pattern)
+		}
+	}
+
+	return nil
+}
+
+// fileFilter selects the .proto files of a source. The zero value selects every file.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+func (s *Source) filter() fileFilter {
+	return fileFilter{include: s.Include, exclude: s.Exclude}
+}
+
+// match reports whether the file at rel, a slash-separated path relative to the source path, is
+// selected: it matches one of the include patterns, if there are any, and none of the excludes.
+func (f fileFilter) match(rel string) bool {
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, func(p string) bool { return doublestar.MatchUnvalidated(p, rel) }) {
+		return false
+	}
+	return !f.excluded(rel)
+}
+
+// excluded reports whether rel matches an exclude pattern. Directories are matched too: a pattern
+// such as "internal/**" matches the directory itself, so none of its files need to be fetched.
+func (f fileFilter) excluded(rel string) bool {
+	return slices.ContainsFunc(f.exclude, func(p string) bool { return doublestar.MatchUnvalidated(p, rel) })
+}
+
+// normalizeSources normalizes every source and makes their names unique, as names select the
+// {source} output directory. Names set in the manifest must be unique already; default names are
+// numbered in source order, e.g. "proto" and "proto-2" for two local directories named proto, so
+// they are the same in every run.
+func normalizeSources(sources []Source) error {
+	taken := map[string]bool{}
+	for _, s := range sources {
+		if s.Name == "" {
+			continue
+		}
+		if taken[s.Name] {
+			return fmt.Errorf("source name '%s' is used by more than one source", s.Name)
+		}
+		taken[s.Name] = true
+	}
+
+	for i := range sources {
+		explicit := sources[i].Name != ""
+		if err := sources[i].normalize(); err != nil {
+			return err
+		}
+		if explicit {
+			continue
+		}
+
+		name := sources[i].Name
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s-%d", sources[i].Name, n)
+		}
+		sources[i].Name = name
+		taken[name] = true
+	}
+	return nil
+}
+
+func renamePaths(rules []RenameRule) []string {
+	paths := make([]string, 0, len(rules)*2)
+	for _, r := range rules {
+		paths = append(paths, r.From, r.To)
+	}
+	return paths
+}
+
+// mountPath maps a slash-separated path relative to the source root to its path in the workspace.
+func (m Mount) mountPath(rel string) string {
+	rel = path.Clean(rel)
+
+	if strip := strings.Trim(m.StripPrefix, "/"); strip != "" {
+		if rel == strip {
+			rel = "."
+		} else if strings.HasPrefix(rel, strip+"/") {
+			rel = strings.TrimPrefix(rel, strip+"/")
+		}
+	}
+
+	for _, r := range m.Rename {
+		from := strings.Trim(r.From, "/")
+		if rel == from {
+			rel = strings.Trim(r.To, "/")
+			break
+		}
+		if strings.HasPrefix(rel, from+"/") {
+			rel = path.Join(strings.Trim(r.To, "/"), strings.TrimPrefix(rel, from+"/"))
+			break
+		}
+	}
+
+	prefix := ""
+	if m.Prefix != nil {
+		prefix = strings.Trim(*m.Prefix, "/")
+	}
+	return path.Join(prefix, rel)
+}
@@ -0,0 +1,262 @@
+package protogen
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// DefaultSSHHost is the host private repos are cloned from over SSH.
+const DefaultSSHHost = "github.com"
+
+// SSHOptions configures how private repos are cloned when no token is given.
+type SSHOptions struct {
+	// KeyPath is a private key used instead of the keys of the agent and ~/.ssh/config.
+	KeyPath string
+	// KnownHostsPath replaces the known_hosts files ssh reads by default.
+	KnownHostsPath string
+	// Host is the host of the clone URLs, either github.com or a Host alias of ~/.ssh/config.
+	Host string
+}
+
+// normalize expands a leading ~ in the paths and checks that the files exist.
+func (o *SSHOptions) normalize() error {
+	o.KeyPath = expandHome(o.KeyPath)
+	o.KnownHostsPath = expandHome(o.KnownHostsPath)
+
+	if o.KeyPath != "" {
+		if _, err := os.Stat(o.KeyPath); err != nil {
+			return fmt.Errorf("SSH key '%s' is not readable: %w", o.KeyPath, err)
+		}
+	}
+	if o.KnownHostsPath != "" {
+		if _, err := os.Stat(o.KnownHostsPath); err != nil {
+			return fmt.Errorf("known_hosts file '%s' is not readable: %w", o.KnownHostsPath, err)
+		}
+	}
+	if o.Host == "" || strings.ContainsAny(o.Host, " \t/:@") {
+		return fmt.Errorf("invalid SSH host '%s'", o.Host)
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~/ with the home directory, which shells leave in place in
+// --flag=~/path arguments.
+func expandHome(p string) string {
+	if !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(homeDir, p[2:])
+}
+
+// checkSSHAuth checks that ssh has a key to authenticate with: the explicit key, an identity held
+// by ssh-agent, a default key file, or an IdentityFile configured for the host in ~/.ssh/config.
+func checkSSHAuth(logger *slog.Logger, opts SSHOptions) error {
+	if opts.KeyPath != "" {
+		return nil
+	}
+
+	if n, err := sshAgentIdentities(); err == nil && n > 0 {
+		logger.Debug("using ssh-agent identities", "count", n)
+		return nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	sshDir := filepath.Join(homeDir, ".ssh")
+
+	for _, key := range []string{"id_rsa", "id_ed25519", "id_ecdsa"} {
+		if _, err := os.Stat(filepath.Join(sshDir, key)); err == nil {
+			return nil
+		}
+	}
+
+	if hasIdentityFile(filepath.Join(sshDir, "config"), opts.Host) {
+		return nil
+	}
+
+	return errors.New("no SSH key found: pass --ssh-key, add a key to ssh-agent, or configure an IdentityFile for the host in ~/.ssh/config")
+}
+
+// sshAgentIdentities returns the number of keys held by the agent at SSH_AUTH_SOCK.
+func sshAgentIdentities() (int, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return 0, errors.New("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to the agent at '%s': %w", socket, err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list agent identities: %w", err)
+	}
+	return len(keys), nil
+}
+
+// hasIdentityFile reports whether the ssh config at configPath sets an IdentityFile for host.
+// Only Host blocks are understood; Match blocks and Include directives are ignored.
+func hasIdentityFile(configPath, host string) bool {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	matching := true // Options before the first Host line apply to every host.
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.ReplaceAll(scanner.Text(), "=", " "))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "host":
+			matching = sshHostMatches(fields[1:], host)
+		case "match":
+			matching = false
+		case "identityfile":
+			if matching {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sshHostMatches reports whether host matches the patterns of a Host line, including negations.
+func sshHostMatches(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// sshEndpoint is the host name and port ssh connects to for a host of the clone URLs.
+type sshEndpoint struct {
+	hostname string
+	port     string
+}
+
+// resolveSSHHost asks ssh which host name and port it connects to for host, which may be a Host
+// alias of ~/.ssh/config. When ssh cannot tell, host is used as is on port 22.
+func resolveSSHHost(host string) sshEndpoint {
+	output, err := exec.Command("ssh", "-G", host).Output()
+	if err != nil {
+		output = nil
+	}
+	return parseSSHEndpoint(host, string(output))
+}
+
+// parseSSHEndpoint reads the host name and port from the configuration ssh -G prints for host.
+func parseSSHEndpoint(host, config string) sshEndpoint {
+	endpoint := sshEndpoint{hostname: host, port: "22"}
+	for _, line := range strings.Split(config, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch key {
+		case "hostname":
+			endpoint.hostname = value
+		case "port":
+			endpoint.port = value
+		}
+	}
+	return endpoint
+}
+
+func (e sshEndpoint) address() string {
+	return net.JoinHostPort(e.hostname, e.port)
+}
+
+// knownHostsName is the name ssh stores the host key of the endpoint under in known_hosts.
+func (e sshEndpoint) knownHostsName() string {
+	if e.port == "22" {
+		return e.hostname
+	}
+	return "[" + e.hostname + "]:" + e.port
+}
+
+// addHostKeyHint tells how to add the host key of the endpoint to known_hosts.
+func (e sshEndpoint) addHostKeyHint() string {
+	keyscan := "ssh-keyscan " + e.hostname
+	if e.port != "22" {
+		keyscan = "ssh-keyscan -p " + e.port + " " + e.hostname
+	}
+	fingerprints := "the fingerprints published by the host's administrators"
+	if e.hostname == DefaultSSHHost {
+		fingerprints = "https://docs.github.com/en/authentication/keeping-your-account-and-data-secure/githubs-ssh-key-fingerprints"
+	}
+	return fmt.Sprintf("add the host key with '%s >> ~/.ssh/known_hosts' after verifying it against %s", keyscan, fingerprints)
+}
+
+// gitSSHCommand returns the GIT_SSH_COMMAND used for clones. ssh never prompts, and refuses hosts
+// that are missing from known_hosts or present a different key.
+func (o SSHOptions) gitSSHCommand() string {
+	args := []string{"ssh", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}
+	if o.KnownHostsPath != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+shellQuote(o.KnownHostsPath))
+	}
+	if o.KeyPath != "" {
+		args = append(args, "-i", shellQuote(o.KeyPath), "-o", "IdentitiesOnly=yes")
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for sh, which git uses to run GIT_SSH_COMMAND.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshCloneError turns the stderr of a failed git command into an error telling what went wrong.
+func sshCloneError(host string, stderr string, err error) error {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if !strings.HasPrefix(line, "Cloning into") {
+			lines = append(lines, line)
+		}
+	}
+	output := strings.Join(lines, "\n")
+	switch {
+	case strings.Contains(output, "REMOTE HOST IDENTIFICATION HAS CHANGED"):
+		endpoint := resolveSSHHost(host)
+		return WithKind(ErrorKindAuth, fmt.Errorf("the host key of '%s' does not match known_hosts; remove the old key with 'ssh-keygen -R %s', then %s: %s", host, endpoint.knownHostsName(), endpoint.addHostKeyHint(), output))
+	case strings.Contains(output, "Host key verification failed"):
+		return WithKind(ErrorKindAuth, fmt.Errorf("the host key of '%s' is not in known_hosts; %s, or pass --ssh-known-hosts: %s", host, resolveSSHHost(host).addHostKeyHint(), output))
+	case strings.Contains(output, "Permission denied"):
+		return WithKind(ErrorKindAuth, fmt.Errorf("SSH authentication to '%s' failed, check that the key is added to your GitHub account and can read the repository: %s", host, output))
+	case strings.Contains(output, "Repository not found"), strings.Contains(output, "does not appear to be a git repository"):
+		return WithKind(ErrorKindFetch, fmt.Errorf("repository not found or not readable with this SSH key: %s", output))
+	case strings.Contains(output, "couldn't find remote ref"), strings.Contains(output, "not our ref"):
+		return WithKind(ErrorKindFetch, fmt.Errorf("ref not found, check that the branch, tag or commit SHA exists: %s", output))
+	case output != "":
+		return fmt.Errorf("failed to fetch repository using git: %w: %s", err, output)
+	default:
+		return fmt.Errorf("failed to fetch repository using git: %w", err)
+	}
+}
@@ -0,0 +1,57 @@
+package protogen
+
+import "testing"
+
+func TestParseSSHEndpoint(t *testing.T) {
+	tests := []struct {
+		name, host, config     string
+		wantAddress, wantKnown string
+		wantHint               string
+	}{
+		{
+			name:        "github.com",
+			host:        "github.com",
+			config:      "user git\nhostname github.com\nport 22\n",
+			wantAddress: "github.com:22",
+			wantKnown:   "github.com",
+			wantHint:    "add the host key with 'ssh-keyscan github.com >> ~/.ssh/known_hosts' after verifying it against https://docs.github.com/en/authentication/keeping-your-account-and-data-secure/githubs-ssh-key-fingerprints",
+		},
+		{
+			name:        "alias",
+			host:        "github-work",
+			config:      "hostname github.com\nport 22\nidentityfile ~/.ssh/id_work\n",
+			wantAddress: "github.com:22",
+			wantKnown:   "github.com",
+			wantHint:    "add the host key with 'ssh-keyscan github.com >> ~/.ssh/known_hosts' after verifying it against https://docs.github.com/en/authentication/keeping-your-account-and-data-secure/githubs-ssh-key-fingerprints",
+		},
+		{
+			name:        "alias on another port",
+			host:        "ghe",
+			config:      "hostname github.acme.com\nport 2222\n",
+			wantAddress: "github.acme.com:2222",
+			wantKnown:   "[github.acme.com]:2222",
+			wantHint:    "add the host key with 'ssh-keyscan -p 2222 github.acme.com >> ~/.ssh/known_hosts' after verifying it against the fingerprints published by the host's administrators",
+		},
+		{
+			name:        "ssh unavailable",
+			host:        "github.acme.com",
+			wantAddress: "github.acme.com:22",
+			wantKnown:   "github.acme.com",
+			wantHint:    "add the host key with 'ssh-keyscan github.acme.com >> ~/.ssh/known_hosts' after verifying it against the fingerprints published by the host's administrators",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := parseSSHEndpoint(tt.host, tt.config)
+			if got := endpoint.address(); got != tt.wantAddress {
+				t.Errorf("got address %q, want %q", got, tt.wantAddress)
+			}
+			if got := endpoint.knownHostsName(); got != tt.wantKnown {
+				t.Errorf("got known_hosts name %q, want %q", got, tt.wantKnown)
+			}
+			if got := endpoint.addHostKeyHint(); got != tt.wantHint {
+				t.Errorf("got hint %q, want %q", got, tt.wantHint)
+			}
+		})
+	}
+}
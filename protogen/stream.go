@@ -0,0 +1,73 @@
+package protogen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+// prefixWriter writes every line written to it to w, prefixed with prefix. A line is written
+// once its newline is, or by Flush when the output ends without one.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	line   []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.line = append(p.line, b...)
+			break
+		}
+		p.line = append(p.line, b[:i+1]...)
+		if err := p.Flush(); err != nil {
+			return 0, err
+		}
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// Flush writes the pending line, adding the missing newline.
+func (p *prefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	line := p.line
+	if line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	p.line = p.line[:0]
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
+
+// errReader is a reader failing with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// streamOutput returns an exec option copying the stdout and stderr of a command to stdout
+// and stderr while it runs, where tcexec.Multiplexed only returns the output once the
+// command exited. The reader Exec returns is empty, or fails when the output could not be
+// copied.
+func streamOutput(stdout, stderr io.Writer) tcexec.ProcessOption {
+	return tcexec.ProcessOptionFunc(func(opts *tcexec.ProcessOptions) {
+		// Options are applied once before the exec is created, without a reader.
+		if opts.Reader == nil {
+			return
+		}
+		if _, err := stdcopy.StdCopy(stdout, stderr, opts.Reader); err != nil {
+			opts.Reader = errReader{fmt.Errorf("copying output: %w", err)}
+			return
+		}
+		opts.Reader = strings.NewReader("")
+	})
+}
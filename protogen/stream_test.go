@@ -0,0 +1,61 @@
+package protogen
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := &prefixWriter{w: &out, prefix: "[js buf generate] "}
+	for _, chunk := range []string{"first li", "ne\nsecond line\n", "\nunterminated"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[js buf generate] first line\n[js buf generate] second line\n[js buf generate] \n[js buf generate] unterminated\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestStreamOutput(t *testing.T) {
+	// The attached exec stream carries stdout and stderr with Docker's multiplexing headers.
+	var stream bytes.Buffer
+	stdcopy.NewStdWriter(&stream, stdcopy.Stdout).Write([]byte("npm http fetch\n"))
+	stdcopy.NewStdWriter(&stream, stdcopy.Stderr).Write([]byte("npm warn deprecated\n"))
+	stdcopy.NewStdWriter(&stream, stdcopy.Stdout).Write([]byte("added 12 packages\n"))
+
+	var stdout, stderr strings.Builder
+	opts := tcexec.NewProcessOptions([]string{"npm", "install"})
+	option := streamOutput(&stdout, &stderr)
+	option.Apply(opts)
+	opts.Reader = &stream
+	option.Apply(opts)
+
+	if stdout.String() != "npm http fetch\nadded 12 packages\n" || stderr.String() != "npm warn deprecated\n" {
+		t.Errorf("got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+	if rest, err := io.ReadAll(opts.Reader); err != nil || len(rest) != 0 {
+		t.Errorf("got reader with %q and error %v, want an empty one", rest, err)
+	}
+}
+
+func TestStreamOutputCorruptStream(t *testing.T) {
+	opts := tcexec.NewProcessOptions([]string{"buf", "generate"})
+	opts.Reader = strings.NewReader("not a multiplexed stream")
+	streamOutput(io.Discard, io.Discard).Apply(opts)
+
+	if _, err := io.ReadAll(opts.Reader); err == nil {
+		t.Error("got no error reading the output of a corrupt stream, want one")
+	}
+}
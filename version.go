@@ -0,0 +1,52 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time by the Makefile with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// getBuildInfo returns the build information of the binary, falling back to the module version
+// and VCS information recorded by the Go toolchain when it was not set at build time.
+func getBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if goBuildInfo, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && goBuildInfo.Main.Version != "" {
+			info.Version = goBuildInfo.Main.Version
+		}
+		for _, setting := range goBuildInfo.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+}